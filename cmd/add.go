@@ -17,9 +17,13 @@ limitations under the License.
 package cmd
 
 import (
+	"flash/pkg/index"
+	"fmt"
 	"log"
 	"net/rpc"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -28,6 +32,11 @@ import (
 var addCmd = &cobra.Command{
 	Use:   "add <path>",
 	Short: "Adds a file or directory to the index",
+	Long: `Adds a file or directory to the index.
+
+The size, extension, depth, hidden and link flags set which files under the directory are
+indexed. They replace any settings the directory already has, and if it has already
+been added, it's rescanned using the new settings.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		path, err := filepath.Abs(args[0])
 		if err != nil {
@@ -39,6 +48,17 @@ var addCmd = &cobra.Command{
 			log.Fatal(err)
 		}
 
+		if policy, ok := policyFlags(cmd, path); ok {
+			var updated bool
+			if err := client.Call("Handler.SetPolicy", policy, &updated); err != nil {
+				log.Fatal(err)
+			}
+			if updated {
+				fmt.Println("Updated the settings of", path)
+				return
+			}
+		}
+
 		var success bool
 		err = client.Call("Handler.Add", path, &success)
 		if err != nil {
@@ -48,6 +68,54 @@ var addCmd = &cobra.Command{
 	Args: cobra.ExactArgs(1),
 }
 
+// policyFlags returns the policy set by the flags, or false if none of them were given
+func policyFlags(cmd *cobra.Command, dir string) (index.Policy, bool) {
+	flags := cmd.Flags()
+	policy := index.Policy{Dir: dir}
+	if !flags.Changed("max-size") && !flags.Changed("ext") && !flags.Changed("exclude-ext") &&
+		!flags.Changed("max-depth") && !flags.Changed("hidden") && !flags.Changed("follow-links") {
+		return policy, false
+	}
+
+	size, _ := flags.GetString("max-size")
+	if size != "" {
+		var err error
+		if policy.MaxSize, err = parseSize(size); err != nil {
+			log.Fatal(err)
+		}
+	}
+	policy.Extensions, _ = flags.GetStringSlice("ext")
+	policy.Exclude, _ = flags.GetStringSlice("exclude-ext")
+	policy.MaxDepth, _ = flags.GetInt("max-depth")
+	policy.Hidden, _ = flags.GetBool("hidden")
+	policy.FollowLinks, _ = flags.GetBool("follow-links")
+	return policy, true
+}
+
+// parseSize parses a number of bytes, which may have a K, M, G or T suffix
+func parseSize(s string) (int64, error) {
+	str := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	multiplier := int64(1)
+	if n := len(str); n > 0 {
+		if i := strings.IndexByte("KMGT", str[n-1]); i >= 0 {
+			multiplier = 1 << (10 * (i + 1))
+			str = str[:n-1]
+		}
+	}
+
+	size, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size: %v", s)
+	}
+	return size * multiplier, nil
+}
+
 func init() {
+	addCmd.Flags().String("max-size", "", "Skip files larger than this size, such as 500K or 20M")
+	addCmd.Flags().StringSlice("ext", nil, "Only index files with these extensions")
+	addCmd.Flags().StringSlice("exclude-ext", nil, "Skip files with these extensions")
+	addCmd.Flags().Int("max-depth", 0, "Only index files this many directories deep, 1 being the directory itself")
+	addCmd.Flags().Bool("hidden", false, "Index hidden files and directories")
+	addCmd.Flags().Bool("follow-links", false, "Follow symbolic links, indexing each file once however many links lead to it")
 	rootCmd.AddCommand(addCmd)
 }
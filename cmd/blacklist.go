@@ -18,9 +18,11 @@ package cmd
 
 import (
 	"flash/pkg/monitordaemon"
+	"flash/tools/blacklist"
 	"fmt"
 	"log"
 	"net/rpc"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -28,12 +30,18 @@ import (
 // blacklistCmd represents the blacklist command
 var blacklistCmd = &cobra.Command{
 	Use:   "blacklist",
-	Short: "Blacklists all files which match a given regex",
+	Short: "Blacklists all files which match a given regex or glob",
 }
 
 var blacklistAddCmd = &cobra.Command{
 	Use:   "add \"<regex>\"",
-	Short: "Blacklists all files which match a given regex",
+	Short: "Blacklists all files which match a given regex or glob",
+	Long: `Blacklists all files which match a given regex, or a glob if --glob is given.
+Files which are already indexed and match the pattern are removed from the index.
+
+A glob without a slash, such as "*.iso" or "node_modules", matches any file or
+directory with that name. Otherwise it matches from the start of the path, and **
+matches any number of directories, as in "/home/*/mail/**".`,
 	Run: func(cmd *cobra.Command, args []string) {
 		client, err := rpc.DialHTTP("tcp", "localhost:1234")
 		if err != nil {
@@ -41,7 +49,7 @@ var blacklistAddCmd = &cobra.Command{
 		}
 
 		var success bool
-		err = client.Call("Handler.BlacklistAdd", args[0], &success)
+		err = client.Call("Handler.BlacklistAdd", blacklistPattern(cmd, args[0]), &success)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -51,7 +59,7 @@ var blacklistAddCmd = &cobra.Command{
 
 var blacklistRemoveCmd = &cobra.Command{
 	Use:   "remove \"<regex>\"",
-	Short: "Removes the given regex from the blacklist",
+	Short: "Removes the given regex or glob from the blacklist, indexing the files it excluded",
 	Run: func(cmd *cobra.Command, args []string) {
 		client, err := rpc.DialHTTP("tcp", "localhost:1234")
 		if err != nil {
@@ -59,7 +67,7 @@ var blacklistRemoveCmd = &cobra.Command{
 		}
 
 		var success bool
-		err = client.Call("Handler.BlacklistRemove", args[0], &success)
+		err = client.Call("Handler.BlacklistRemove", blacklistPattern(cmd, args[0]), &success)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -88,7 +96,17 @@ var blacklistListCmd = &cobra.Command{
 	},
 }
 
+// blacklistPattern adds the glob prefix to the pattern if the glob flag is set
+func blacklistPattern(cmd *cobra.Command, pattern string) string {
+	if glob, _ := cmd.Flags().GetBool("glob"); glob && !strings.HasPrefix(pattern, blacklist.GlobPrefix) {
+		return blacklist.GlobPrefix + pattern
+	}
+	return pattern
+}
+
 func init() {
+	blacklistAddCmd.Flags().BoolP("glob", "g", false, "The pattern is a glob rather than a regex")
+	blacklistRemoveCmd.Flags().BoolP("glob", "g", false, "The pattern is a glob rather than a regex")
 	blacklistCmd.AddCommand(blacklistAddCmd)
 	blacklistCmd.AddCommand(blacklistRemoveCmd)
 	blacklistCmd.AddCommand(blacklistListCmd)
@@ -0,0 +1,71 @@
+/*
+Copyright © 2020 Andrew Cullis <acullis68@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"flash/pkg/index"
+	"flash/pkg/monitordaemon"
+	"fmt"
+	"log"
+	"net/rpc"
+
+	"github.com/spf13/cobra"
+)
+
+// dupesCmd represents the dupes command
+var dupesCmd = &cobra.Command{
+	Use:   "dupes",
+	Short: "Lists groups of duplicate and near-duplicate documents",
+	Run: func(cmd *cobra.Command, args []string) {
+		threshold, _ := cmd.Flags().GetFloat64("threshold")
+		if threshold < index.MinThreshold || threshold > 1 {
+			log.Fatalf("Threshold must be between %v and 1", index.MinThreshold)
+		}
+
+		client, err := rpc.DialHTTP("tcp", "localhost:1234")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var results monitordaemon.Duplicates
+		err = client.Call("Handler.Dupes", threshold, &results)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if len(results.Groups) == 0 {
+			fmt.Println("No duplicates found")
+			return
+		}
+
+		for i, group := range results.Groups {
+			if group.Exact {
+				fmt.Printf("%d: exact duplicates\n", i+1)
+			} else {
+				fmt.Printf("%d: %.0f%% similar\n", i+1, group.Similarity*100)
+			}
+			for _, path := range group.Paths {
+				fmt.Printf("   %v\n", path)
+			}
+		}
+	},
+}
+
+func init() {
+	dupesCmd.Flags().Float64P("threshold", "t", 0.9, "The minimum similarity between near-duplicates, from 0.75 to 1")
+	rootCmd.AddCommand(dupesCmd)
+}
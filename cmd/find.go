@@ -33,6 +33,7 @@ var findCmd = &cobra.Command{
 	Short: "Search the index for a query",
 	Run: func(cmd *cobra.Command, args []string) {
 		n, _ := cmd.Flags().GetInt("num_results")
+		name, _ := cmd.Flags().GetString("index")
 		query := args[0]
 
 		client, err := rpc.DialHTTP("tcp", "localhost:1234")
@@ -42,7 +43,7 @@ var findCmd = &cobra.Command{
 
 		start := time.Now()
 		var results monitordaemon.Results
-		err = client.Call("Handler.Search", monitordaemon.Query{Str: query, N: n}, &results)
+		err = client.Call("Handler.Search", monitordaemon.Query{Str: query, N: n, Index: name}, &results)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -58,9 +59,23 @@ var findCmd = &cobra.Command{
 			return
 		}
 
+		long, _ := cmd.Flags().GetBool("long")
 		fmt.Printf("Found %d results in %v\n", len(results.Paths), time.Since(start))
 		for i, path := range results.Paths {
 			fmt.Printf("%d: %v\n", i+1, path)
+			if !long {
+				continue
+			}
+
+			var info monitordaemon.DocInfo
+			if err := client.Call("Handler.DocInfo", monitordaemon.DocInfoRequest{Path: path, Index: name}, &info); err != nil {
+				fmt.Printf("   %v\n", err)
+				continue
+			}
+			fmt.Printf("   %v\n", info.Details())
+			if props := info.Properties(); props != "" {
+				fmt.Printf("   %v\n", props)
+			}
 		}
 	},
 	Args: cobra.ExactArgs(1),
@@ -69,5 +84,7 @@ var findCmd = &cobra.Command{
 func init() {
 	findCmd.Flags().IntP("num_results", "n", 10, "The number of results that will be returned")
 	findCmd.Flags().Bool("ifl", false, "Open the top result of the search immediately")
+	findCmd.Flags().BoolP("long", "l", false, "Show the metadata of each result")
+	findCmd.Flags().StringP("index", "i", "", "The index to search, instead of the current one")
 	rootCmd.AddCommand(findCmd)
 }
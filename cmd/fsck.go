@@ -0,0 +1,105 @@
+/*
+Copyright © 2020 Andrew Cullis <acullis68@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"flash/pkg/index"
+	"flash/pkg/index/partition"
+	"fmt"
+	"log"
+	"net/rpc"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// fsckCmd represents the fsck command
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Checks the index for corruption, and optionally repairs it",
+	Long: `Checks that the partitions, dictionaries and info files of the index are
+readable, and that the doclist and id list agree with the posting lists.
+With --repair, dictionaries are rebuilt, broken partitions are dropped and
+the affected directories are reindexed when the daemon is next started.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		repair, _ := cmd.Flags().GetBool("repair")
+
+		if client, err := rpc.DialHTTP("tcp", "localhost:1234"); err == nil {
+			client.Close()
+			log.Fatal("The daemon must be stopped before checking the index, run 'sudo flash daemon stop'")
+		}
+
+		indexpath := viper.GetString("indexpath")
+		if version, err := partition.CollectorVersion(indexpath, "postings"); err == nil && version != partition.Version {
+			log.Fatalf("Index uses format version %d, run 'flash index migrate' first", version)
+		}
+
+		problems := index.Check(indexpath)
+		var lost []string
+		for _, p := range problems {
+			fmt.Println(p)
+			if p.LosesData() {
+				lost = append(lost, p.Path)
+			}
+		}
+
+		if !repair {
+			if len(lost) > 0 {
+				fmt.Println("The index has broken files, run 'flash fsck --repair' to fix them")
+				return
+			}
+		} else if err := index.Repair(indexpath, problems); err != nil {
+			log.Fatal(err)
+		}
+
+		i := index.Load(indexpath)
+		inconsistent, affected := i.Verify(repair)
+		for _, p := range inconsistent {
+			fmt.Println(p)
+		}
+
+		if len(problems) == 0 && len(inconsistent) == 0 {
+			fmt.Println("No problems found")
+			return
+		}
+		if !repair {
+			fmt.Println("Run 'flash fsck --repair' to fix the index")
+			return
+		}
+
+		i.ClearMemory()
+		dirs := viper.GetStringSlice("dirs")
+		roots := index.AffectedRoots(affected, dirs)
+		if len(lost) > 0 {
+			// Entries from the dropped partitions can't be attributed to a root
+			roots = dirs
+		}
+		if err := index.RequestReindex(indexpath, roots); err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println("Repaired the index")
+		for _, root := range roots {
+			fmt.Printf("%v will be reindexed when the daemon is started\n", root)
+		}
+	},
+}
+
+func init() {
+	fsckCmd.Flags().BoolP("repair", "r", false, "Repair the problems which are found")
+	rootCmd.AddCommand(fsckCmd)
+}
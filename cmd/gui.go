@@ -27,10 +27,12 @@ var guiCmd = &cobra.Command{
 	Use:   "gui",
 	Short: "Opens a graphical search box",
 	Run: func(cmd *cobra.Command, args []string) {
-		gui.Show()
+		name, _ := cmd.Flags().GetString("index")
+		gui.Show(name)
 	},
 }
 
 func init() {
+	guiCmd.Flags().StringP("index", "i", "", "The index to search, instead of the current one")
 	rootCmd.AddCommand(guiCmd)
 }
@@ -0,0 +1,357 @@
+/*
+Copyright © 2020 Andrew Cullis <acullis68@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"flash/pkg/index"
+	"flash/pkg/index/partition"
+	"flash/pkg/monitordaemon"
+	"fmt"
+	"log"
+	"net/rpc"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// indexCmd represents the index command
+var indexCmd = &cobra.Command{
+	Use:   "index",
+	Short: "Used to manage the indexes",
+}
+
+var indexCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Creates a new, empty index",
+	Long: `Creates a new, empty index with its own directories, blacklist and policies. It's
+stored under the flash home directory unless a path is given. Run 'flash index use'
+to make it the current index, which add, remove and blacklist manage`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path, _ := cmd.Flags().GetString("path")
+		if path != "" {
+			var err error
+			if path, err = filepath.Abs(path); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if client, err := rpc.DialHTTP("tcp", "localhost:1234"); err == nil {
+			defer client.Close()
+			var success bool
+			if err := client.Call("Handler.CreateIndex", index.Profile{Name: args[0], Path: path}, &success); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			if _, err := index.CreateProfile(args[0], path); err != nil {
+				log.Fatal(err)
+			}
+			if err := viper.WriteConfig(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		fmt.Println("Created index", args[0])
+	},
+}
+
+var indexListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the indexes, marking the current one",
+	Run: func(cmd *cobra.Command, args []string) {
+		var list monitordaemon.IndexList
+		if client, err := rpc.DialHTTP("tcp", "localhost:1234"); err == nil {
+			defer client.Close()
+			if err := client.Call("Handler.ListIndexes", "", &list); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			list.Current = index.CurrentProfile().Name
+			list.Indexes = index.Profiles()
+		}
+
+		for _, p := range list.Indexes {
+			marker := " "
+			if p.Name == list.Current {
+				marker = "*"
+			}
+			fmt.Printf("%v %v\t%v (%d directories)\n", marker, p.Name, p.Path, len(p.Dirs))
+		}
+	},
+}
+
+var indexUseCmd = &cobra.Command{
+	Use:   "use <name>",
+	Short: "Makes the named index the current one",
+	Long: `Makes the named index the current one. Commands which manage an index, such as add,
+remove and blacklist, apply to the current index, and find and gui search it unless
+another is given with --index`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if client, err := rpc.DialHTTP("tcp", "localhost:1234"); err == nil {
+			defer client.Close()
+			var success bool
+			if err := client.Call("Handler.UseIndex", args[0], &success); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			if err := index.UseProfile(args[0]); err != nil {
+				log.Fatal(err)
+			}
+			if err := viper.WriteConfig(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		fmt.Println("Using index", args[0])
+	},
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrades the index to the current format version",
+	Run: func(cmd *cobra.Command, args []string) {
+		if client, err := rpc.DialHTTP("tcp", "localhost:1234"); err == nil {
+			client.Close()
+			log.Fatal("The daemon must be stopped before migrating, run 'sudo flash daemon stop'")
+		}
+
+		from, err := index.Migrate(flagProfile(cmd).Path)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if from == partition.Version {
+			fmt.Println("Index is already up to date")
+		} else {
+			fmt.Printf("Migrated index from version %d to %d\n", from, partition.Version)
+		}
+	},
+}
+
+var scanStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Shows the progress of the scan for changes made while the daemon was stopped",
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := rpc.DialHTTP("tcp", "localhost:1234")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		name, _ := cmd.Flags().GetString("index")
+		var progress index.ScanProgress
+		err = client.Call("Handler.ScanStatus", name, &progress)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		switch {
+		case progress.Running:
+			fmt.Printf("Scanning %v\n", progress.Root)
+		case progress.Finished:
+			fmt.Println("Scan finished")
+		default:
+			fmt.Println("Scan not started")
+			return
+		}
+		fmt.Printf("Checked: %d\nIndexed: %d\nUnchanged: %d\nDeleted: %d\n",
+			progress.Checked, progress.Indexed, progress.Skipped, progress.Deleted)
+	},
+}
+
+var compactCmd = &cobra.Command{
+	Use:   "compact",
+	Short: "Removes deleted documents from the index's partitions",
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := rpc.DialHTTP("tcp", "localhost:1234")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		name, _ := cmd.Flags().GetString("index")
+		var res index.CompactResult
+		err = client.Call("Handler.Compact", name, &res)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if res.Partitions == 0 {
+			fmt.Println("No partitions need compacting")
+		} else {
+			fmt.Printf("Compacted %d partitions, reclaimed %d bytes\n", res.Partitions, res.Reclaimed)
+		}
+	},
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export <file>",
+	Short: "Exports a snapshot of the index and the watched directories to a file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// The daemon writes the snapshot so that it's consistent with the index in memory
+		if client, err := rpc.DialHTTP("tcp", "localhost:1234"); err == nil {
+			defer client.Close()
+			var success bool
+			name, _ := cmd.Flags().GetString("index")
+			if err := client.Call("Handler.Export", monitordaemon.ExportRequest{Path: path, Index: name}, &success); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			f, err := os.Create(path)
+			if err != nil {
+				log.Fatal(err)
+			}
+			p := flagProfile(cmd)
+			config := index.BackupConfig{
+				Dirs:      p.Dirs,
+				Blacklist: p.Blacklist,
+				Roots:     p.Roots,
+			}
+			err = index.ExportPath(p.Path, f, config)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				log.Fatal(err)
+			}
+		}
+		fmt.Println("Exported index to", path)
+	},
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Replaces the index and the watched directories with an exported snapshot",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if client, err := rpc.DialHTTP("tcp", "localhost:1234"); err == nil {
+			client.Close()
+			log.Fatal("The daemon must be stopped before importing, run 'sudo flash daemon stop'")
+		}
+
+		p := flagProfile(cmd)
+		f, err := os.Open(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+
+		config, err := index.Import(f, p.Path)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		p.Dirs = config.Dirs
+		p.Blacklist = config.Blacklist
+		p.Roots = config.Roots
+		index.SaveProfile(p)
+		if err := viper.WriteConfig(); err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println("Imported index to", p.Path)
+		if version, err := partition.CollectorVersion(p.Path, "postings"); err == nil && version < partition.Version {
+			fmt.Printf("The index uses an older format, run 'flash index migrate --index %v' to upgrade it\n", p.Name)
+		}
+	},
+}
+
+var dumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "Writes the postings, doclist and id list to stdout as JSON Lines",
+	Run: func(cmd *cobra.Command, args []string) {
+		if client, err := rpc.DialHTTP("tcp", "localhost:1234"); err == nil {
+			client.Close()
+			log.Fatal("The daemon must be stopped before dumping the index, run 'sudo flash daemon stop'")
+		}
+
+		i, err := index.Open(flagProfile(cmd))
+		if err != nil {
+			log.Fatalf("%v\nRun 'flash index migrate' to upgrade the index", err)
+		}
+		w := bufio.NewWriter(os.Stdout)
+		if err := i.Dump(w); err != nil {
+			log.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var loadCmd = &cobra.Command{
+	Use:   "load <dump> <dir>",
+	Short: "Builds a new index in the directory from a dump",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if _, err := os.Stat(args[1]); err == nil {
+			log.Fatalf("%v already exists", args[1])
+		}
+
+		f, err := os.Open(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+
+		i, err := index.LoadDump(f, args[1])
+		if err != nil {
+			os.RemoveAll(args[1])
+			log.Fatal(err)
+		}
+		fmt.Printf("Loaded %d documents into %v\n", i.GetInfo().NumDocs, args[1])
+	},
+}
+
+// flagProfile returns the profile of the index named by the command's --index flag, or
+// the current index if it isn't given
+func flagProfile(cmd *cobra.Command) index.Profile {
+	name, _ := cmd.Flags().GetString("index")
+	if name == "" {
+		return index.CurrentProfile()
+	}
+	p, ok := index.GetProfile(name)
+	if !ok {
+		log.Fatalf("No index named %v", name)
+	}
+	return p
+}
+
+func init() {
+	for _, c := range []*cobra.Command{migrateCmd, scanStatusCmd, compactCmd, exportCmd, importCmd, dumpCmd} {
+		c.Flags().StringP("index", "i", "", "The index to use, instead of the current one")
+	}
+	indexCreateCmd.Flags().String("path", "", "The directory to store the index in")
+	indexCmd.AddCommand(indexCreateCmd)
+	indexCmd.AddCommand(indexListCmd)
+	indexCmd.AddCommand(indexUseCmd)
+	indexCmd.AddCommand(migrateCmd)
+	indexCmd.AddCommand(scanStatusCmd)
+	indexCmd.AddCommand(compactCmd)
+	indexCmd.AddCommand(exportCmd)
+	indexCmd.AddCommand(importCmd)
+	indexCmd.AddCommand(dumpCmd)
+	indexCmd.AddCommand(loadCmd)
+	rootCmd.AddCommand(indexCmd)
+}
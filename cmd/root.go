@@ -18,10 +18,12 @@ package cmd
 
 import (
 	"flash/pkg/index"
+	"flash/pkg/index/partition"
 	"flash/pkg/monitordaemon"
 	"fmt"
 	"os"
 	"os/user"
+	"runtime"
 
 	"github.com/spf13/cobra"
 
@@ -71,10 +73,16 @@ func initConfig() {
 	viper.Set("flashhome", flashhome)
 	viper.SetDefault("indexpath", flashhome+"index")
 	viper.SetDefault("dirs", []string{})
+	viper.SetDefault("current_index", index.DefaultProfile)
 	viper.SetDefault("tikapath", flashhome+"tika.jar")
 	viper.SetDefault("tikaport", "9998")
 	viper.SetDefault("blacklist", []string{})
 	viper.SetDefault("gui_results", 5)
+	viper.SetDefault("workers", runtime.NumCPU())
+	viper.SetDefault("merge_factor", partition.DefaultMergePolicy.Factor)
+	viper.SetDefault("max_segment_size", partition.DefaultMergePolicy.MaxSegmentSize)
+	viper.SetDefault("compact_tombstones", 1000)
+	viper.SetDefault("memory_budget", partition.DefaultMemoryBudget)
 
 	_, err = os.Stat(home + "/.config/flash.json")
 	if err != nil && username != "" {
@@ -0,0 +1,81 @@
+/*
+Copyright © 2020 Andrew Cullis <acullis68@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"flash/pkg/index"
+	"flash/pkg/monitordaemon"
+	"fmt"
+	"log"
+	"net/rpc"
+
+	"github.com/spf13/cobra"
+)
+
+// statsCmd represents the stats command
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Shows statistics about the contents of the index",
+	Run: func(cmd *cobra.Command, args []string) {
+		top, _ := cmd.Flags().GetInt("top")
+		name, _ := cmd.Flags().GetString("index")
+
+		client, err := rpc.DialHTTP("tcp", "localhost:1234")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var stats index.Stats
+		err = client.Call("Handler.Stats", monitordaemon.StatsRequest{NumTerms: top, Index: name}, &stats)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("Documents: %d\n", stats.NumDocs)
+		fmt.Printf("Average length: %.1f terms\n", stats.AvgLength)
+		fmt.Printf("Vocabulary: %d terms\n", stats.Vocabulary)
+		fmt.Printf("Memory: %d of %d bytes\n", stats.Memory, stats.Budget)
+
+		fmt.Println("\nPartitions:")
+		for _, c := range stats.Collectors {
+			fmt.Printf("  %v\n", c.Extension)
+			for _, p := range c.Partitions {
+				name := fmt.Sprintf("generation %d", p.Generation)
+				if p.Generation == 0 {
+					name = "memory"
+				}
+				fmt.Printf("    %-14v %9d keys %12d bytes %7d tombstones\n", name, p.Keys, p.Bytes, p.Tombstones)
+			}
+		}
+
+		fmt.Println("\nTop terms:")
+		for i, t := range stats.TopTerms {
+			fmt.Printf("  %d: %v (%d documents)\n", i+1, t.Term, t.DocFreq)
+		}
+
+		fmt.Println("\nFile types:")
+		for _, t := range stats.FileTypes {
+			fmt.Printf("  %-40v %7d documents %12d bytes\n", t.Type, t.Docs, t.Bytes)
+		}
+	},
+}
+
+func init() {
+	statsCmd.Flags().IntP("top", "t", 10, "The number of most frequent terms to show")
+	statsCmd.Flags().StringP("index", "i", "", "The index to describe, instead of the current one")
+	rootCmd.AddCommand(statsCmd)
+}
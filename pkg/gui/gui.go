@@ -15,8 +15,8 @@ import (
 const escape uint = 65307
 const enter uint = 65293
 
-// Show displays the gui
-func Show() {
+// Show displays the gui, searching the named index or the current one if the name is empty
+func Show(indexName string) {
 	// Init window
 	gtk.Init(nil)
 
@@ -45,7 +45,7 @@ func Show() {
 	// Add events
 	entry.Connect("search-changed", func() {
 		win.Resize(600, entry.GetAllocatedHeight())
-		handleSearch(entry, results)
+		handleSearch(entry, results, indexName)
 	})
 
 	entry.Connect("key-press-event", func(_ *gtk.SearchEntry, ev *gdk.Event) {
@@ -53,7 +53,7 @@ func Show() {
 		// fmt.Println(keyEvent.KeyVal())
 		if keyEvent.KeyVal() == enter {
 			win.Resize(600, entry.GetAllocatedHeight())
-			handleSearch(entry, results)
+			handleSearch(entry, results, indexName)
 		}
 	})
 
@@ -85,7 +85,7 @@ func Show() {
 	gtk.Main()
 }
 
-func handleSearch(entry *gtk.SearchEntry, resultsCol *gtk.ListBox) {
+func handleSearch(entry *gtk.SearchEntry, resultsCol *gtk.ListBox, indexName string) {
 	text, err := entry.GetText()
 	if err != nil {
 		log.Fatal(err)
@@ -108,13 +108,17 @@ func handleSearch(entry *gtk.SearchEntry, resultsCol *gtk.ListBox) {
 	}
 
 	var results monitordaemon.Results
-	err = client.Call("Handler.Search", monitordaemon.Query{Str: text, N: viper.GetInt("gui_results")}, &results)
+	err = client.Call("Handler.Search", monitordaemon.Query{Str: text, N: viper.GetInt("gui_results"), Index: indexName}, &results)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	for _, path := range results.Paths {
-		row := newResult(path)
+		var info monitordaemon.DocInfo
+		if err := client.Call("Handler.DocInfo", monitordaemon.DocInfoRequest{Path: path, Index: indexName}, &info); err != nil {
+			info = monitordaemon.DocInfo{Path: path}
+		}
+		row := newResult(&info)
 		resultsCol.Add(row)
 		resultsCol.ShowAll()
 	}
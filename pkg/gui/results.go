@@ -1,7 +1,9 @@
 package gui
 
 import (
+	"flash/pkg/monitordaemon"
 	"fmt"
+	"html"
 	"log"
 	"path/filepath"
 	"strings"
@@ -15,15 +17,15 @@ type result struct {
 	*gtk.ListBoxRow
 }
 
-func newResult(path string) *result {
+func newResult(info *monitordaemon.DocInfo) *result {
 	row, _ := gtk.ListBoxRowNew()
 	container, _ := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 5)
 
-	row.SetName(path)
+	row.SetName(info.Path)
 	theme, _ := gtk.IconThemeGetDefault()
 
-	icon := getIcon(path, theme)
-	content := getContent(path)
+	icon := getIcon(info.Path, theme)
+	content := getContent(info)
 
 	container.Add(icon)
 	container.Add(content)
@@ -33,22 +35,33 @@ func newResult(path string) *result {
 	return &result{ListBoxRow: row}
 }
 
-func getContent(path string) *gtk.Box {
+func getContent(info *monitordaemon.DocInfo) *gtk.Box {
 	container, _ := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 1)
-	name := filepath.Base(path)
+	name := filepath.Base(info.Path)
+	if info.Title != "" {
+		name = fmt.Sprintf("%s — %s", name, info.Title)
+	}
 
 	title, _ := gtk.LabelNew("")
 	title.SetXAlign(0)
-	markup := fmt.Sprintf("<span weight=\"500\" size=\"%d\">%s</span>", 12*pango.PANGO_SCALE, name)
+	markup := fmt.Sprintf("<span weight=\"500\" size=\"%d\">%s</span>", 12*pango.PANGO_SCALE, html.EscapeString(name))
 	title.SetMarkup(markup)
 
-	info, _ := gtk.LabelNew("")
-	info.SetXAlign(0)
-	markup = fmt.Sprintf("<span weight=\"300\" size=\"%d\" color=\"#505050\">%s</span>", 10*pango.PANGO_SCALE, path)
-	info.SetMarkup(markup)
+	location, _ := gtk.LabelNew("")
+	location.SetXAlign(0)
+	markup = fmt.Sprintf("<span weight=\"300\" size=\"%d\" color=\"#505050\">%s</span>", 10*pango.PANGO_SCALE, html.EscapeString(info.Path))
+	location.SetMarkup(markup)
 
 	container.Add(title)
-	container.Add(info)
+	container.Add(location)
+
+	if info.Size > 0 || info.MIME != "" {
+		details, _ := gtk.LabelNew("")
+		details.SetXAlign(0)
+		markup = fmt.Sprintf("<span weight=\"300\" size=\"%d\" color=\"#808080\">%s</span>", 9*pango.PANGO_SCALE, html.EscapeString(info.Details()))
+		details.SetMarkup(markup)
+		container.Add(details)
+	}
 
 	return container
 }
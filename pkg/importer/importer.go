@@ -3,22 +3,48 @@ package importer
 import (
 	"context"
 	"flash/tools/text"
+	"mime"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/google/go-tika/tika"
 	"github.com/spf13/viper"
 )
 
+// Metadata holds the properties of a file which are reported by tika
+type Metadata struct {
+	MIME    string
+	Title   string
+	Author  string
+	Created time.Time
+}
+
+// Tika reports the same properties under different keys depending on the file type
+var (
+	titleKeys   = []string{"dc:title", "title"}
+	authorKeys  = []string{"dc:creator", "meta:author", "Author"}
+	createdKeys = []string{"dcterms:created", "meta:creation-date", "Creation-Date"}
+)
+
 // GetTextChannel Returns a channel from which the text of a file is exported
 func GetTextChannel(filepath string) chan string {
+	channel, _ := GetText(filepath)
+	return channel
+}
+
+// GetText returns a channel from which the text of a file is exported, along with
+// the metadata of the file. The metadata is filled in before the channel is closed
+func GetText(filepath string) (chan string, *Metadata) {
 	channel := make(chan string, 100)
-	go getText(filepath, channel)
+	meta := &Metadata{}
+	go getText(filepath, channel, meta)
 
-	return channel
+	return channel, meta
 }
 
-func getText(path string, c chan string) error {
+func getText(path string, c chan string, meta *Metadata) error {
 	defer close(c)
 
 	stat, err := os.Stat(path)
@@ -36,12 +62,48 @@ func getText(path string, c chan string) error {
 
 	tikaport := viper.GetString("tikaport")
 	client := tika.NewClient(nil, "http://localhost:"+tikaport)
-	body, _ := client.Parse(context.Background(), file)
+	docs, _ := client.MetaRecursive(context.Background(), file)
+
+	// The first document is the file itself, followed by any embedded documents
+	var body []string
+	for d, doc := range docs {
+		if d == 0 {
+			meta.read(doc)
+		}
+		if content := doc[tika.XTIKAContent]; len(content) > 0 {
+			body = append(body, content[0])
+		}
+	}
 
-	words := strings.Fields(text.Normalize(body + " " + name))
+	if meta.MIME == "" {
+		meta.MIME = mime.TypeByExtension(filepath.Ext(name))
+	}
+
+	words := strings.Fields(text.Normalize(strings.Join(body, " ") + " " + name))
 	for _, word := range words {
 		c <- word
 	}
 
 	return nil
 }
+
+func (m *Metadata) read(doc map[string][]string) {
+	if t, _, err := mime.ParseMediaType(first(doc, "Content-Type")); err == nil {
+		m.MIME = t
+	}
+	m.Title = first(doc, titleKeys...)
+	m.Author = first(doc, authorKeys...)
+	if created, err := time.Parse(time.RFC3339, first(doc, createdKeys...)); err == nil {
+		m.Created = created
+	}
+}
+
+// first returns the first value of the first key which is set
+func first(doc map[string][]string, keys ...string) string {
+	for _, key := range keys {
+		if vals := doc[key]; len(vals) > 0 && vals[0] != "" {
+			return vals[0]
+		}
+	}
+	return ""
+}
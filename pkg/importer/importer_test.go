@@ -52,8 +52,21 @@ func TestMissingFile(t *testing.T) {
 	defer server.StopServer()
 
 	channel := make(chan string)
-	err := getText("missing", channel)
+	err := getText("missing", channel, &Metadata{})
 	if err == nil {
 		t.Fail()
 	}
 }
+
+func TestGetMetadata(t *testing.T) {
+	server := setupServer()
+	defer server.StopServer()
+
+	channel, meta := GetText("./testdata/plaintext_test.txt")
+	for range channel {
+	}
+
+	if meta.MIME != "text/plain" {
+		t.Error(meta.MIME)
+	}
+}
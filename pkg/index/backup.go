@@ -0,0 +1,179 @@
+package index
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flash/pkg/index/partition"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BackupConfig is the configuration saved alongside an exported index
+type BackupConfig struct {
+	Dirs      []string `json:"dirs"`
+	Blacklist []string `json:"blacklist"`
+	Roots     []Policy `json:"roots,omitempty"`
+}
+
+// Archives hold the index files under indexPrefix, and the configuration in configName
+const (
+	indexPrefix = "index/"
+	configName  = "config.json"
+)
+
+// Export writes a snapshot of the index to w. The memory partitions are written to disk
+// first so that the files are consistent, and the index must not be changed until it returns
+func (i *Index) Export(w io.Writer, config BackupConfig) error {
+	i.ClearMemory()
+	return ExportPath(i.dir, w, config)
+}
+
+// ExportPath writes the index at the given path to w as a gzipped tar archive, along
+// with the configuration. The index must not be in use by the daemon
+func ExportPath(indexpath string, w io.Writer, config BackupConfig) error {
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+
+	files, err := ioutil.ReadDir(indexpath)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		// Temporary files are left over from interrupted writes, and aren't part of the index
+		if !f.Mode().IsRegular() || strings.HasSuffix(f.Name(), ".temp") {
+			continue
+		}
+		if err := addFile(archive, filepath.Join(indexpath, f.Name()), indexPrefix+f.Name()); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := addData(archive, configName, data); err != nil {
+		return err
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func addFile(archive *tar.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: name, Mode: 0644, Size: stat.Size(), ModTime: stat.ModTime()}
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+
+	// Only the size recorded in the header is copied, in case the file is appended to
+	_, err = io.CopyN(archive, f, stat.Size())
+	return err
+}
+
+func addData(archive *tar.Writer, name string, data []byte) error {
+	if err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}); err != nil {
+		return err
+	}
+	_, err := archive.Write(data)
+	return err
+}
+
+// Import restores an archive written by Export to the indexpath, replacing any existing
+// index, and returns the configuration which was saved with it. The archive is extracted
+// next to the index first, so the existing index is kept if it can't be read
+func Import(r io.Reader, indexpath string) (BackupConfig, error) {
+	var config BackupConfig
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return config, err
+	}
+	archive := tar.NewReader(gz)
+
+	temp := filepath.Clean(indexpath) + ".import"
+	os.RemoveAll(temp)
+	if err := os.MkdirAll(temp, 0755); err != nil {
+		return config, err
+	}
+	defer os.RemoveAll(temp)
+
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return config, err
+		}
+
+		switch name := header.Name; {
+		case name == configName:
+			if err := json.NewDecoder(archive).Decode(&config); err != nil {
+				return config, fmt.Errorf("invalid configuration: %w", err)
+			}
+		case strings.HasPrefix(name, indexPrefix):
+			// Files are only extracted directly into the index directory
+			base := strings.TrimPrefix(name, indexPrefix)
+			if base == "" || base != filepath.Base(base) || base == ".." {
+				return config, fmt.Errorf("invalid file in archive: %v", name)
+			}
+			if err := extractFile(archive, filepath.Join(temp, base)); err != nil {
+				return config, err
+			}
+		}
+	}
+
+	version, err := partition.CollectorVersion(temp, "postings")
+	if os.IsNotExist(err) {
+		return config, errors.New("archive doesn't contain an index")
+	} else if err != nil {
+		return config, err
+	}
+	if version > partition.Version {
+		return config, fmt.Errorf("index uses format version %d, which is newer than this version of flash supports", version)
+	}
+
+	old := filepath.Clean(indexpath) + ".old"
+	os.RemoveAll(old)
+	if err := os.Rename(indexpath, old); err != nil && !os.IsNotExist(err) {
+		return config, err
+	}
+	if err := os.Rename(temp, indexpath); err != nil {
+		os.Rename(old, indexpath)
+		return config, err
+	}
+	return config, os.RemoveAll(old)
+}
+
+func extractFile(r io.Reader, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
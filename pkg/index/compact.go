@@ -0,0 +1,60 @@
+package index
+
+import (
+	"flash/pkg/index/partition"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CompactResult reports the partitions which were rewritten by a compaction
+type CompactResult struct {
+	Partitions int
+	Reclaimed  int64
+}
+
+// Compact rewrites the partitions which have at least minTombstones tombstones, leaving
+// out the entries of deleted documents. The lock is only held while each compaction is
+// started and swapped in, so the index can be searched and updated while they run
+func (i *Index) Compact(lock *sync.RWMutex, minTombstones int) CompactResult {
+	var res CompactResult
+	for _, c := range append([]*partition.Collector{i.collector}, i.docs.Collectors()...) {
+		for {
+			lock.Lock()
+			if i.closed {
+				lock.Unlock()
+				return res
+			}
+			done, compacting := c.Compact(minTombstones)
+			lock.Unlock()
+			if done == nil {
+				break
+			}
+
+			// A merge which was already running is finished before compacting, but isn't counted
+			<-done
+			lock.Lock()
+			reclaimed, compacted := c.FinishMerge()
+			lock.Unlock()
+			if compacting && compacted {
+				res.Partitions++
+				res.Reclaimed += reclaimed
+			}
+		}
+	}
+	return res
+}
+
+// Idle returns true if the index hasn't been changed for the given duration
+func (i *Index) Idle(d time.Duration) bool {
+	return time.Since(i.lastChange()) >= d
+}
+
+// touch records that the index was changed
+func (i *Index) touch() {
+	atomic.StoreInt64(&i.changed, time.Now().UnixNano())
+}
+
+func (i *Index) lastChange() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&i.changed))
+}
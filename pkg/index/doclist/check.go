@@ -0,0 +1,103 @@
+package doclist
+
+import (
+	"encoding/binary"
+	"errors"
+	"flash/pkg/index/partition"
+	"fmt"
+	"sort"
+)
+
+var extensions = []string{"doclist", "doclist.ids", "doclist.inodes"}
+
+// Check validates the files of each of the doclist's collectors
+func Check(indexpath string) []partition.Problem {
+	problems := partition.Check(indexpath, "doclist", validateDocument)
+	for _, ext := range extensions[1:] {
+		problems = append(problems, partition.Check(indexpath, ext, validateID)...)
+	}
+	return problems
+}
+
+func validateDocument(data []byte) error {
+	if len(data) < 16 || int(binary.LittleEndian.Uint32(data[12:16])) > len(data)-16 {
+		return errors.New("document is truncated")
+	}
+	return nil
+}
+
+func validateID(data []byte) error {
+	if len(data) != 8 {
+		return errors.New("id is truncated")
+	}
+	return nil
+}
+
+// Verify checks that every document is listed under its path in the id list, that
+// every path in the id list points at a document with that path, and that the stats
+// match the documents. If repair is true, the problems are fixed by removing the
+// invalid entries. The paths of documents which must be reindexed are returned
+func (d *DocList) Verify(repair bool) (problems []string, affected []string) {
+	docs := make(map[uint64]*Document)
+	for _, doc := range d.Documents() {
+		docs[doc.id] = doc
+	}
+
+	var missing []*Document
+	var stale []uint64
+	for _, doc := range docs {
+		id, ok := d.pathID(doc.path)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%v is missing from the id list", doc.path))
+			missing = append(missing, doc)
+		} else if id != doc.id {
+			problems = append(problems, fmt.Sprintf("%v is listed under id %d, but document %d has the same path", doc.path, id, doc.id))
+			affected = append(affected, doc.path)
+			stale = append(stale, doc.id)
+		}
+	}
+
+	var dangling []string
+	d.idCollector.Scan(func(path string, entries []partition.Entry) {
+		for _, e := range entries {
+			id, _ := e.(*ID)
+			if doc, ok := docs[id.uint64]; !ok || doc.path != path {
+				problems = append(problems, fmt.Sprintf("%v is listed under id %d, which isn't a document with that path", path, id.uint64))
+				affected = append(affected, path)
+				dangling = append(dangling, path)
+				return
+			}
+		}
+	})
+
+	if n := uint32(len(docs) - len(stale)); d.totalDocs != n {
+		problems = append(problems, fmt.Sprintf("doclist stats count %d documents, expected %d", d.totalDocs, n))
+	}
+
+	if repair {
+		for _, path := range dangling {
+			d.idCollector.Delete(path)
+		}
+		for _, doc := range missing {
+			d.idCollector.Add(doc.path, &ID{doc.id})
+		}
+		for _, id := range stale {
+			d.docCollector.Delete(fmt.Sprint(id))
+		}
+		d.calculateStats()
+		d.Flush()
+	}
+
+	sort.Strings(problems)
+	return problems, affected
+}
+
+func (d *DocList) pathID(path string) (uint64, bool) {
+	entries := d.idCollector.GetEntries(path)
+	if len(entries) == 1 {
+		if id, ok := entries[0].(*ID); ok {
+			return id.uint64, true
+		}
+	}
+	return 0, false
+}
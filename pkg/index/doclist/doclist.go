@@ -4,89 +4,252 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"flash/pkg/index/partition"
 	"flash/tools/readers"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 // DocList type
 type DocList struct {
-	dir          string
-	docCollector *partition.Collector
-	idCollector  *partition.Collector
-	totalDocs    uint32
-	avgLength    float64
+	dir            string
+	docCollector   *partition.Collector
+	idCollector    *partition.Collector
+	inodeCollector *partition.Collector
+	totalDocs      uint32
+	avgLength      float64
+	nextID         uint64
 }
 
 // NewList creates a new doclist
 func NewList(indexpath string) *DocList {
 	l := DocList{
-		dir:          indexpath,
-		docCollector: partition.NewCollector(indexpath, "doclist", NewDocPartition),
-		idCollector:  partition.NewCollector(indexpath, "doclist.ids", NewIDPartition),
+		dir:            indexpath,
+		docCollector:   partition.NewCollector(indexpath, "doclist", NewDocPartition),
+		idCollector:    partition.NewCollector(indexpath, "doclist.ids", NewIDPartition),
+		inodeCollector: partition.NewCollector(indexpath, "doclist.inodes", NewIDPartition),
+		nextID:         1,
 	}
 
 	return &l
 }
 
-// Load loads a doclist for the given index
-func Load(indexpath string) *DocList {
+// Load loads a doclist for the given index, an error is only returned if the
+// doclist was written using an incompatible format
+func Load(indexpath string) (*DocList, error) {
 	l := NewList(indexpath)
 
-	if l.docCollector.Load() == nil && l.idCollector.Load() == nil {
-		l.loadStats()
+	err := l.docCollector.Load()
+	if err == nil {
+		err = l.idCollector.Load()
+	}
+	if err == nil {
+		if l.docCollector.Recovered() {
+			l.calculateStats()
+		} else {
+			err = l.loadStats()
+		}
+	}
+	if errors.Is(err, partition.ErrIncompatible) {
+		return nil, err
 	}
 
-	return l
+	// Indexes created before ids were allocated have no inode map, it is filled in as files are updated
+	if err := l.inodeCollector.Load(); errors.Is(err, partition.ErrIncompatible) {
+		return nil, err
+	}
+	if err != nil || l.nextID == 0 || l.docCollector.Recovered() {
+		l.calculateNextID()
+	}
+	return l, nil
 }
 
-// Add adds the given file to the doclist
-func (d *DocList) Add(id uint64, file string, length uint32) {
-	doc := &Document{
-		id:     id,
-		path:   file,
-		length: length,
-	}
+// SetMergePolicy changes the policy used to merge the partitions of each of the doclist's collectors
+func (d *DocList) SetMergePolicy(policy partition.MergePolicy) {
+	d.docCollector.SetMergePolicy(policy)
+	d.idCollector.SetMergePolicy(policy)
+	d.inodeCollector.SetMergePolicy(policy)
+}
 
-	fmt.Println("Adding", file)
+// SetBudget moves each of the doclist's collectors to the given memory budget
+func (d *DocList) SetBudget(b *partition.Budget) {
+	d.docCollector.SetBudget(b)
+	d.idCollector.SetBudget(b)
+	d.inodeCollector.SetBudget(b)
+}
+
+// NewID allocates an id for a new document. Ids are allocated in order, and
+// are never reused for a different file
+func (d *DocList) NewID() uint64 {
+	id := d.nextID
+	d.nextID++
+	return id
+}
+
+// Add adds the given document to the doclist
+func (d *DocList) Add(doc *Document) {
+	fmt.Println("Adding", doc.path)
 
 	d.docCollector.Add(fmt.Sprint(doc.id), doc)
-	d.idCollector.Add(file, &ID{id})
-	d.addLength(int(length))
+	d.idCollector.Add(doc.path, &ID{doc.id})
+	d.addInode(doc)
+	d.addLength(int(doc.length))
 	d.totalDocs++
+
+	// Documents loaded from elsewhere may have ids which haven't been allocated yet
+	if doc.id >= d.nextID {
+		d.nextID = doc.id + 1
+	}
+}
+
+// Move changes the path of a document which has been renamed, keeping its id. False
+// is returned if the document has since been removed or moved elsewhere
+func (d *DocList) Move(doc *Document, path string) bool {
+	current, ok := d.FetchID(doc.id)
+	if !ok || current.path != doc.path {
+		return false
+	}
+
+	fmt.Println("Moving", current.path, "to", path)
+
+	key := fmt.Sprint(doc.id)
+	moved := *current
+	moved.path = path
+	d.docCollector.Delete(key)
+	d.docCollector.Add(key, &moved)
+	d.idCollector.Delete(current.path)
+	d.idCollector.Add(path, &ID{doc.id})
+	return true
+}
+
+// Touch updates the modification time and size of a document whose file changed without
+// its content changing, so that the next check doesn't hash it again. False is returned
+// if the document was removed or moved since it was fetched
+func (d *DocList) Touch(doc *Document, modTime, size int64) bool {
+	current, ok := d.FetchID(doc.id)
+	if !ok || current.path != doc.path {
+		return false
+	}
+
+	key := fmt.Sprint(doc.id)
+	touched := *current
+	touched.SetStat(modTime, size)
+	d.docCollector.Delete(key)
+	d.docCollector.Add(key, &touched)
+	return true
+}
+
+// addInode maps the documents inode to its id. The mapping is kept after the document
+// is deleted, so that a file which is removed and recreated by a rename keeps its id
+func (d *DocList) addInode(doc *Document) {
+	if doc.device == 0 && doc.inode == 0 {
+		return
+	}
+
+	key := inodeKey(doc.device, doc.inode)
+	if id, ok := d.inodeID(key); ok {
+		if id == doc.id {
+			return
+		}
+		d.inodeCollector.Delete(key)
+	}
+	d.inodeCollector.Add(key, &ID{doc.id})
+}
+
+// FetchInode returns the document with the given device and inode. If the inode is
+// shared by hard links, the one which was indexed most recently is returned
+func (d *DocList) FetchInode(device, inode uint64) (doc *Document, ok bool) {
+	if id, ok := d.inodeID(inodeKey(device, inode)); ok {
+		if doc, ok := d.FetchID(id); ok && doc.device == device && doc.inode == inode {
+			return doc, true
+		}
+	}
+	return nil, false
+}
+
+// InodeID returns the id which was last used for the given device and inode,
+// even if the document with that id has since been deleted
+func (d *DocList) InodeID(device, inode uint64) (uint64, bool) {
+	return d.inodeID(inodeKey(device, inode))
+}
+
+func (d *DocList) inodeID(key string) (uint64, bool) {
+	entries := d.inodeCollector.GetEntries(key)
+	if len(entries) == 1 {
+		if id, ok := entries[0].(*ID); ok {
+			return id.uint64, true
+		}
+	}
+	return 0, false
+}
+
+func inodeKey(device, inode uint64) string {
+	return fmt.Sprintf("%d:%d", device, inode)
 }
 
 // Delete removes a document from the doclist
 func (d *DocList) Delete(id string, path string) {
+	d.deleteDoc(id)
+	d.idCollector.Delete(path)
+}
+
+// deleteDoc removes the document with the given id, without removing its path from the id list
+func (d *DocList) deleteDoc(id string) {
 	bufs, impls := d.docCollector.GetBuffers(id)
-	totalLen := 0
+	totalLen, found := 0, false
 	for i := range bufs {
-		entry, _ := impls[i].Decode(id, bufs[i])
-		if doc, ok := entry.(*Document); ok {
+		// A document which was readded may have invalidated copies in older partitions
+		entry, ok := impls[i].Decode(id, bufs[i])
+		if doc, isDoc := entry.(*Document); ok && isDoc {
 			totalLen += int(doc.length)
+			found = true
 		}
 	}
 
-	if len(bufs) > 0 {
+	if found {
 		d.removeLength(totalLen)
 		d.totalDocs--
 		d.docCollector.Delete(id)
 	}
-	d.idCollector.Delete(path)
 }
 
-// GetIDs returns the docIDs of the matching docs
-func (d *DocList) GetIDs(path string) []*ID {
-	var ids []*ID
-	for _, entry := range d.idCollector.GetMatching(path) {
+// GetSubtree returns the ids of the document at the path and of every document under
+// it, keyed by their paths. Only whole path elements match, so /a/b doesn't include /a/bc
+func (d *DocList) GetSubtree(path string) map[string]*ID {
+	ids := make(map[string]*ID)
+	add := func(path string, entry partition.Entry) {
 		if id, ok := entry.(*ID); ok {
-			ids = append(ids, id)
+			ids[path] = id
 		}
 	}
+
+	path = filepath.Clean(path)
+	for _, entry := range d.idCollector.GetEntries(path) {
+		add(path, entry)
+	}
+
+	prefix := path
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	d.idCollector.GetPrefix(prefix, add)
 	return ids
 }
 
+// Documents returns every document in the doclist
+func (d *DocList) Documents() []*Document {
+	var docs []*Document
+	for _, entry := range d.docCollector.GetAll() {
+		if doc, ok := entry.(*Document); ok {
+			docs = append(docs, doc)
+		}
+	}
+	return docs
+}
+
 // FetchID gets the document with the given id
 func (d *DocList) FetchID(id uint64) (doc *Document, ok bool) {
 	entries := d.docCollector.GetEntries(fmt.Sprint(id))
@@ -131,36 +294,151 @@ func (d *DocList) NumDocs() uint32 {
 	return d.totalDocs
 }
 
+// Stats returns statistics about the partitions of each of the doclist's collectors
+func (d *DocList) Stats() []partition.CollectorStats {
+	return []partition.CollectorStats{
+		d.docCollector.Stats(),
+		d.idCollector.Stats(),
+		d.inodeCollector.Stats(),
+	}
+}
+
+// Collectors returns the collectors which store the doclist, in the order of the documents, ids and inodes
+func (d *DocList) Collectors() []*partition.Collector {
+	return []*partition.Collector{d.docCollector, d.idCollector, d.inodeCollector}
+}
+
+// Flush journals any buffered changes to the doclist
+func (d *DocList) Flush() {
+	d.docCollector.Flush()
+	d.idCollector.Flush()
+	d.inodeCollector.Flush()
+}
+
 // ClearMemory writes any remaining partitions to disk
 func (d *DocList) ClearMemory() {
 	d.docCollector.ClearMemory()
 	d.idCollector.ClearMemory()
+	d.inodeCollector.ClearMemory()
 	d.dumpStats()
 }
 
-func (d *DocList) dumpStats() {
-	f, err := os.Create(fmt.Sprintf("%v/doclist.stats", d.dir))
+// MigrateLegacy upgrades a doclist written before the format was versioned
+func MigrateLegacy(indexpath string) error {
+	for _, ext := range []string{"doclist", "doclist.ids"} {
+		if err := partition.MigrateLegacy(indexpath, ext, nil); err != nil {
+			return err
+		}
+	}
+	return partition.AddHeader(fmt.Sprintf("%v/doclist.stats", indexpath))
+}
+
+// MigrateManifest replaces the info files of the doclist collectors with manifests
+func MigrateManifest(indexpath string) error {
+	for _, ext := range []string{"doclist", "doclist.ids"} {
+		if err := partition.MigrateManifest(indexpath, ext); err != nil {
+			return err
+		}
+	}
+	return partition.SetVersion(fmt.Sprintf("%v/doclist.stats", indexpath), 2)
+}
+
+// MigrateBlocks front codes the keys of the partition files of the doclist collectors
+func MigrateBlocks(indexpath string) error {
+	for _, ext := range []string{"doclist", "doclist.ids", "doclist.inodes"} {
+		if err := partition.MigrateBlocks(indexpath, ext); err != nil {
+			return err
+		}
+	}
+	return partition.SetVersion(fmt.Sprintf("%v/doclist.stats", indexpath), 3)
+}
+
+// MigrateDocuments rewrites the documents of the doclist with every field, as those written
+// before version 4 end before the fields which were introduced after them
+func MigrateDocuments(indexpath string) error {
+	err := partition.MigrateValues(indexpath, "doclist", func(data []byte) []byte {
+		return decodeOutdated(bytes.NewBuffer(data)).Bytes().Bytes()
+	})
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
-	defer f.Close()
 
+	for _, ext := range []string{"doclist.ids", "doclist.inodes"} {
+		if err := partition.MigrateValues(indexpath, ext, nil); err != nil {
+			return err
+		}
+	}
+	return partition.SetVersion(fmt.Sprintf("%v/doclist.stats", indexpath), 4)
+}
+
+// MigrateDocSets updates the version of the doclist collectors, which are unchanged by
+// the documents of each postings partition being recorded
+func MigrateDocSets(indexpath string) error {
+	for _, ext := range []string{"doclist", "doclist.ids", "doclist.inodes"} {
+		if err := partition.MigrateInfo(indexpath, ext, nil); err != nil {
+			return err
+		}
+	}
+	return partition.SetVersion(fmt.Sprintf("%v/doclist.stats", indexpath), 5)
+}
+
+func (d *DocList) dumpStats() {
 	buf := new(bytes.Buffer)
+	partition.WriteHeader(buf)
 	binary.Write(buf, binary.LittleEndian, d.totalDocs)
 	binary.Write(buf, binary.LittleEndian, d.avgLength)
-	buf.WriteTo(f)
+	binary.Write(buf, binary.LittleEndian, d.nextID)
+
+	if err := partition.WriteFile(fmt.Sprintf("%v/doclist.stats", d.dir), buf.Bytes()); err != nil {
+		fmt.Println(err)
+	}
 }
 
-func (d *DocList) loadStats() {
-	f, err := os.Open(fmt.Sprintf("%v/doclist.stats", d.dir))
+// calculateStats recalculates the stats from every document, used when
+// the stats on disk are outdated after recovering from a crash
+func (d *DocList) calculateStats() {
+	d.totalDocs = 0
+	d.avgLength = 0
+	for _, doc := range d.Documents() {
+		d.addLength(int(doc.length))
+		d.totalDocs++
+	}
+}
+
+// calculateNextID sets the next id to follow every id in the doclist and inode map
+func (d *DocList) calculateNextID() {
+	d.nextID = 1
+	for _, entry := range append(d.docCollector.GetAll(), d.inodeCollector.GetAll()...) {
+		var id uint64
+		switch e := entry.(type) {
+		case *Document:
+			id = e.id
+		case *ID:
+			id = e.uint64
+		}
+		if id >= d.nextID {
+			d.nextID = id + 1
+		}
+	}
+}
+
+func (d *DocList) loadStats() error {
+	path := fmt.Sprintf("%v/doclist.stats", d.dir)
+	f, err := os.Open(path)
 	if err != nil {
 		fmt.Println(err)
-		return
+		return err
 	}
 	defer f.Close()
 
 	r := bufio.NewReader(f)
+	if err := partition.ReadHeader(r, path); err != nil {
+		return err
+	}
 	d.totalDocs = readers.ReadUint32(r)
 	d.avgLength = readers.ReadFloat64(r)
+
+	// Stats written before ids were allocated end after the average length
+	d.nextID = readers.ReadUint64(r)
+	return nil
 }
@@ -7,8 +7,6 @@ import (
 	"flash/tools/readers"
 	"fmt"
 	"io"
-	"log"
-	"os"
 	"strconv"
 )
 
@@ -16,6 +14,7 @@ import (
 type DocPartition struct {
 	data        map[string]*Document
 	invalidDocs map[uint64]bool
+	bytes       int64
 }
 
 // NewDocPartition returns a new partition
@@ -31,7 +30,10 @@ func NewDocPartition() partition.Implementation {
 // Add adds the document with the given id to the partition
 func (p *DocPartition) Add(id string, val partition.Entry) {
 	if doc, ok := val.(*Document); ok {
+		p.bytes -= p.usage(id)
 		p.data[id] = doc
+		p.bytes += p.usage(id)
+		delete(p.invalidDocs, doc.id)
 	}
 }
 
@@ -49,9 +51,18 @@ func (p *DocPartition) Delete(id string) {
 	}
 
 	// If partition is in memory, remove the doc
+	p.bytes -= p.usage(id)
 	delete(p.data, id)
 }
 
+// usage returns the approximate number of bytes used by the document with the given id
+func (p *DocPartition) usage(id string) int64 {
+	if doc, ok := p.data[id]; ok {
+		return int64(len(id)) + partition.MapEntrySize + doc.memoryUsage()
+	}
+	return 0
+}
+
 // Get returns the document with the given id
 func (p *DocPartition) Get(id string) (val partition.Entry, ok bool) {
 	if val, ok := p.data[id]; ok {
@@ -62,38 +73,91 @@ func (p *DocPartition) Get(id string) (val partition.Entry, ok bool) {
 
 // Decode takes a byte buffer and decodes it to a document
 func (p *DocPartition) Decode(id string, buf *bytes.Buffer) (partition.Entry, bool) {
-	docID := readers.ReadUint64(buf)
-	length := readers.ReadUint32(buf)
-	plen := readers.ReadUint32(buf)
-	pbuf := make([]byte, plen)
-	io.ReadFull(buf, pbuf)
+	doc := decodeDocument(buf)
+
+	valid := true
+	if _, ok := p.invalidDocs[doc.id]; ok {
+		valid = false
+	}
+
+	return doc, valid
+}
 
+func decodeDocument(buf *bytes.Buffer) *Document {
 	doc := Document{
-		id:     docID,
-		path:   string(pbuf),
-		length: length,
+		id:     readers.ReadUint64(buf),
+		length: readers.ReadUint32(buf),
+		path:   readString(buf),
 	}
 
-	valid := true
-	if _, ok := p.invalidDocs[docID]; ok {
-		valid = false
+	doc.hash = make([]byte, readers.ReadUint32(buf))
+	io.ReadFull(buf, doc.hash)
+	doc.fingerprint = readers.ReadUint64(buf)
+	doc.modTime = int64(readers.ReadUint64(buf))
+	doc.size = int64(readers.ReadUint64(buf))
+	doc.device = readers.ReadUint64(buf)
+	doc.inode = readers.ReadUint64(buf)
+	doc.meta.MIME = readString(buf)
+	doc.meta.Title = readString(buf)
+	doc.meta.Author = readString(buf)
+	doc.meta.Created = int64(readers.ReadUint64(buf))
+	doc.meta.Owner = readers.ReadUint32(buf)
+	return &doc
+}
+
+// decodeOutdated decodes a document written before version 4, which ends before
+// any of the fields which were introduced after it was written
+func decodeOutdated(buf *bytes.Buffer) *Document {
+	doc := Document{
+		id:     readers.ReadUint64(buf),
+		length: readers.ReadUint32(buf),
+		path:   readString(buf),
 	}
 
-	return &doc, valid
+	if buf.Len() > 0 {
+		doc.hash = make([]byte, readers.ReadUint32(buf))
+		io.ReadFull(buf, doc.hash)
+		doc.fingerprint = readers.ReadUint64(buf)
+	}
+	if buf.Len() > 0 {
+		doc.modTime = int64(readers.ReadUint64(buf))
+		doc.size = int64(readers.ReadUint64(buf))
+	}
+	if buf.Len() > 0 {
+		doc.device = readers.ReadUint64(buf)
+		doc.inode = readers.ReadUint64(buf)
+	}
+	if buf.Len() > 0 {
+		doc.meta.MIME = readString(buf)
+		doc.meta.Title = readString(buf)
+		doc.meta.Author = readString(buf)
+		doc.meta.Created = int64(readers.ReadUint64(buf))
+		doc.meta.Owner = readers.ReadUint32(buf)
+	}
+	return &doc
+}
+
+func readString(buf *bytes.Buffer) string {
+	str := make([]byte, readers.ReadUint32(buf))
+	io.ReadFull(buf, str)
+	return string(str)
 }
 
-// Merge will merge the partition readers, if there is more than one, this means that
-// there was a collision in the doclist
+// Merge will merge the partition readers. An id is only in more than one partition
+// if it was deleted and readded, in which case only one of the documents is valid
 func (p *DocPartition) Merge(readers []*partition.Reader, impls []partition.Implementation) partition.Entry {
-	if len(readers) != 1 {
-		log.Fatal("Collision occured in doclist")
-	}
-	readers[0].FetchDataLength()
-	dp, _ := impls[0].(*DocPartition)
-	if doc, ok := dp.Decode(readers[0].CurrentKey(), readers[0].FetchData()); ok {
-		return doc
+	var merged partition.Entry
+	for i := range readers {
+		readers[i].FetchDataLength()
+		dp, _ := impls[i].(*DocPartition)
+		if doc, ok := dp.Decode(readers[i].CurrentKey(), readers[i].FetchData()); ok {
+			if merged != nil {
+				fmt.Println("Collision occured in doclist for id", readers[i].CurrentKey())
+			}
+			merged = doc
+		}
 	}
-	return nil
+	return merged
 }
 
 // Empty returns true if the partition is empty
@@ -113,6 +177,17 @@ func (p *DocPartition) Keys() []string {
 // Clear clears the partition
 func (p *DocPartition) Clear() {
 	p.data = nil
+	p.bytes = 0
+}
+
+// MemoryUsage returns the approximate number of bytes used by the documents held in memory
+func (p *DocPartition) MemoryUsage() int64 {
+	return p.bytes
+}
+
+// Tombstones returns the number of documents which have been invalidated in the partition
+func (p *DocPartition) Tombstones() int {
+	return len(p.invalidDocs)
 }
 
 // LoadInfo loads in information about the partition into memory
@@ -134,31 +209,3 @@ func (p *DocPartition) GetInfo() *bytes.Buffer {
 	}
 	return buf
 }
-
-// GC Performs garbage collection on the partition
-func (p *DocPartition) GC(reader *partition.Reader, out string) (size int) {
-	temp, err := os.Create(out)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-	defer temp.Close()
-
-	running := true
-	for running {
-		reader.FetchDataLength()
-		if doc, ok := p.Decode(reader.CurrentKey(), reader.FetchData()); ok {
-			data := doc.Bytes()
-			buf := new(bytes.Buffer)
-			key := reader.CurrentKey()
-			binary.Write(buf, binary.LittleEndian, uint32(len(key)))
-			binary.Write(buf, binary.LittleEndian, []byte(key))
-			binary.Write(buf, binary.LittleEndian, uint32(data.Len()))
-			binary.Write(buf, binary.LittleEndian, data.Bytes())
-			buf.WriteTo(temp)
-			size++
-		}
-		running = reader.NextKey()
-	}
-	return size
-}
@@ -8,16 +8,71 @@ import (
 
 // Document datastructure
 type Document struct {
-	id     uint64
-	path   string
-	length uint32
+	id          uint64
+	path        string
+	length      uint32
+	hash        []byte
+	fingerprint uint64
+	modTime     int64
+	size        int64
+	device      uint64
+	inode       uint64
+	meta        Metadata
 }
 
+// Metadata describes a file beyond its content
+type Metadata struct {
+	MIME    string
+	Title   string
+	Author  string
+	Created int64
+	Owner   uint32
+}
+
+// Approximate memory used by a document and an id, excluding the document's strings
+const (
+	documentSize = 176
+	idSize       = 8
+)
+
 // ID datastructure
 type ID struct {
 	uint64
 }
 
+// NewDocument creates a document for the file at the given path
+func NewDocument(id uint64, path string, length uint32) *Document {
+	return &Document{id: id, path: path, length: length}
+}
+
+// SetContent sets the hash and simhash fingerprint of the documents content
+func (d *Document) SetContent(hash []byte, fingerprint uint64) {
+	d.hash = hash
+	d.fingerprint = fingerprint
+}
+
+// SetStat sets the modification time, in nanoseconds, and size of the file when it was indexed
+func (d *Document) SetStat(modTime int64, size int64) {
+	d.modTime = modTime
+	d.size = size
+}
+
+// SetInode sets the device and inode of the file, which identify it when it's renamed
+func (d *Document) SetInode(device, inode uint64) {
+	d.device = device
+	d.inode = inode
+}
+
+// SetMetadata sets the metadata of the file
+func (d *Document) SetMetadata(meta Metadata) {
+	d.meta = meta
+}
+
+// memoryUsage returns the approximate number of bytes used by the document
+func (d *Document) memoryUsage() int64 {
+	return documentSize + int64(len(d.path)+len(d.hash)+len(d.meta.MIME)+len(d.meta.Title)+len(d.meta.Author))
+}
+
 // ID returns the documents id
 func (d *Document) ID() uint64 {
 	return d.id
@@ -33,6 +88,36 @@ func (d *Document) Length() uint32 {
 	return d.length
 }
 
+// Hash returns the hash of the documents content
+func (d *Document) Hash() []byte {
+	return d.hash
+}
+
+// Fingerprint returns the simhash fingerprint of the documents terms
+func (d *Document) Fingerprint() uint64 {
+	return d.fingerprint
+}
+
+// ModTime returns the modification time of the file when it was indexed, in nanoseconds
+func (d *Document) ModTime() int64 {
+	return d.modTime
+}
+
+// Size returns the size of the file when it was indexed
+func (d *Document) Size() int64 {
+	return d.size
+}
+
+// Inode returns the device and inode of the file when it was indexed
+func (d *Document) Inode() (device, inode uint64) {
+	return d.device, d.inode
+}
+
+// Metadata returns the metadata of the file when it was indexed
+func (d *Document) Metadata() Metadata {
+	return d.meta
+}
+
 // Bytes creates a byte buffer from the document
 func (d *Document) Bytes() *bytes.Buffer {
 	buf := new(bytes.Buffer)
@@ -40,6 +125,19 @@ func (d *Document) Bytes() *bytes.Buffer {
 	binary.Write(buf, binary.LittleEndian, d.length)
 	binary.Write(buf, binary.LittleEndian, uint32(len(d.path)))
 	binary.Write(buf, binary.LittleEndian, []byte(d.path))
+	binary.Write(buf, binary.LittleEndian, uint32(len(d.hash)))
+	binary.Write(buf, binary.LittleEndian, d.hash)
+	binary.Write(buf, binary.LittleEndian, d.fingerprint)
+	binary.Write(buf, binary.LittleEndian, d.modTime)
+	binary.Write(buf, binary.LittleEndian, d.size)
+	binary.Write(buf, binary.LittleEndian, d.device)
+	binary.Write(buf, binary.LittleEndian, d.inode)
+	for _, str := range []string{d.meta.MIME, d.meta.Title, d.meta.Author} {
+		binary.Write(buf, binary.LittleEndian, uint32(len(str)))
+		buf.WriteString(str)
+	}
+	binary.Write(buf, binary.LittleEndian, d.meta.Created)
+	binary.Write(buf, binary.LittleEndian, d.meta.Owner)
 	return buf
 }
 
@@ -50,6 +148,11 @@ func (id *ID) Bytes() *bytes.Buffer {
 	return buf
 }
 
+// Uint64 returns the value of the id
+func (id *ID) Uint64() uint64 {
+	return id.uint64
+}
+
 func (id *ID) String() string {
 	return fmt.Sprint(id.uint64)
 }
@@ -7,14 +7,13 @@ import (
 	"flash/tools/readers"
 	"fmt"
 	"io"
-	"log"
-	"os"
 )
 
 // IDPartition implements the partition.Implementation interface for doclist
 type IDPartition struct {
 	data        map[string]*ID
 	invalidDocs map[string]bool
+	bytes       int64
 }
 
 // NewIDPartition returns a new partition
@@ -30,7 +29,10 @@ func NewIDPartition() partition.Implementation {
 // Add adds the document with the given id to the partition
 func (p *IDPartition) Add(path string, val partition.Entry) {
 	if id, ok := val.(*ID); ok {
+		p.bytes -= p.usage(path)
 		p.data[path] = id
+		p.bytes += p.usage(path)
+		delete(p.invalidDocs, path)
 	}
 }
 
@@ -43,9 +45,18 @@ func (p *IDPartition) Delete(path string) {
 	}
 
 	// If partition is in memory, remove the doc
+	p.bytes -= p.usage(path)
 	delete(p.data, path)
 }
 
+// usage returns the approximate number of bytes used by the path and its id
+func (p *IDPartition) usage(path string) int64 {
+	if _, ok := p.data[path]; ok {
+		return int64(len(path)) + partition.MapEntrySize + idSize
+	}
+	return 0
+}
+
 // Get returns the document with the given id
 func (p *IDPartition) Get(path string) (val partition.Entry, ok bool) {
 	if val, ok := p.data[path]; ok {
@@ -64,18 +75,21 @@ func (p *IDPartition) Decode(path string, buf *bytes.Buffer) (partition.Entry, b
 	return &ID{id}, true
 }
 
-// Merge will merge the partition readers, if there is more than one, this means that
-// there was a collision in the doclist
+// Merge will merge the partition readers. A key is only in more than one partition
+// if it was deleted and readded, in which case only one of the ids is valid
 func (p *IDPartition) Merge(readers []*partition.Reader, impls []partition.Implementation) partition.Entry {
-	if len(readers) != 1 {
-		log.Fatal("Collision occured in doclist")
-	}
-	readers[0].FetchDataLength()
-	idp, _ := impls[0].(*IDPartition)
-	if doc, ok := idp.Decode(readers[0].CurrentKey(), readers[0].FetchData()); ok {
-		return doc
+	var merged partition.Entry
+	for i := range readers {
+		readers[i].FetchDataLength()
+		idp, _ := impls[i].(*IDPartition)
+		if id, ok := idp.Decode(readers[i].CurrentKey(), readers[i].FetchData()); ok {
+			if merged != nil {
+				fmt.Println("Collision occured in doclist for", readers[i].CurrentKey())
+			}
+			merged = id
+		}
 	}
-	return nil
+	return merged
 }
 
 // Empty returns true if the partition is empty
@@ -95,6 +109,17 @@ func (p *IDPartition) Keys() []string {
 // Clear clears the partition
 func (p *IDPartition) Clear() {
 	p.data = nil
+	p.bytes = 0
+}
+
+// MemoryUsage returns the approximate number of bytes used by the paths held in memory
+func (p *IDPartition) MemoryUsage() int64 {
+	return p.bytes
+}
+
+// Tombstones returns the number of paths which have been invalidated in the partition
+func (p *IDPartition) Tombstones() int {
+	return len(p.invalidDocs)
 }
 
 // LoadInfo loads in information about the partition into memory
@@ -118,32 +143,3 @@ func (p *IDPartition) GetInfo() *bytes.Buffer {
 	}
 	return buf
 }
-
-// GC Performs garbage collection on the partition
-func (p *IDPartition) GC(reader *partition.Reader, out string) (size int) {
-	temp, err := os.Create(out)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-	defer temp.Close()
-
-	running := true
-	for running {
-		reader.FetchDataLength()
-		if id, ok := p.Decode(reader.CurrentKey(), reader.FetchData()); ok {
-			data := id.Bytes()
-			buf := new(bytes.Buffer)
-
-			key := reader.CurrentKey()
-			binary.Write(buf, binary.LittleEndian, uint32(len(key)))
-			binary.Write(buf, binary.LittleEndian, []byte(key))
-			binary.Write(buf, binary.LittleEndian, uint32(data.Len()))
-			binary.Write(buf, binary.LittleEndian, data.Bytes())
-			buf.WriteTo(temp)
-			size++
-		}
-		running = reader.NextKey()
-	}
-	return size
-}
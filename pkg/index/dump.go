@@ -0,0 +1,164 @@
+package index
+
+import (
+	"bufio"
+	"encoding/json"
+	"flash/pkg/index/doclist"
+	"flash/pkg/index/partition"
+	"flash/pkg/index/postinglist"
+	"fmt"
+	"io"
+)
+
+// DumpRecord is a single line of an index dump. Each record holds an entry from one
+// partition of a collector, so a key appears once for every partition it's in
+type DumpRecord struct {
+	Collector string        `json:"collector"`
+	Partition int           `json:"partition"`
+	Term      string        `json:"term,omitempty"`
+	Postings  []DumpPosting `json:"postings,omitempty"`
+	Document  *DumpDocument `json:"document,omitempty"`
+	Path      string        `json:"path,omitempty"`
+	ID        uint64        `json:"id,omitempty"`
+	Deleted   bool          `json:"deleted,omitempty"`
+}
+
+// DumpPosting is a posting of a term in a dumped posting list
+type DumpPosting struct {
+	ID        uint64 `json:"id"`
+	Frequency uint32 `json:"freq"`
+	Deleted   bool   `json:"deleted,omitempty"`
+}
+
+// DumpDocument is a dumped doclist entry
+type DumpDocument struct {
+	ID          uint64 `json:"id"`
+	Path        string `json:"path"`
+	Length      uint32 `json:"length"`
+	Hash        []byte `json:"hash,omitempty"`
+	Fingerprint uint64 `json:"fingerprint,omitempty"`
+	ModTime     int64  `json:"modtime,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	Device      uint64 `json:"device,omitempty"`
+	Inode       uint64 `json:"inode,omitempty"`
+	MIME        string `json:"mime,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Author      string `json:"author,omitempty"`
+	Created     int64  `json:"created,omitempty"`
+	Owner       uint32 `json:"owner,omitempty"`
+}
+
+// Dump writes the postings, doclist and id list to w as JSON Lines, including entries
+// which have been deleted but not yet removed from their partitions
+func (i *Index) Dump(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	var err error
+	write := func(r *DumpRecord) {
+		if err == nil {
+			err = enc.Encode(r)
+		}
+	}
+
+	i.collector.Dump(func(gen int, term string, all, valid partition.Entry) {
+		l, ok := all.(*postinglist.List)
+		if !ok {
+			return
+		}
+		validDocs := make(map[uint64]bool)
+		if v, ok := valid.(*postinglist.List); ok {
+			for _, id := range v.GetDocs() {
+				validDocs[id] = true
+			}
+		}
+
+		r := &DumpRecord{Collector: "postings", Partition: gen, Term: term}
+		for _, id := range l.GetDocs() {
+			r.Postings = append(r.Postings, DumpPosting{id, l.Frequency(id), !validDocs[id]})
+		}
+		write(r)
+	})
+
+	collectors := i.docs.Collectors()
+	collectors[0].Dump(func(gen int, _ string, all, valid partition.Entry) {
+		if doc, ok := all.(*doclist.Document); ok {
+			write(&DumpRecord{Collector: "doclist", Partition: gen, Document: dumpDocument(doc), Deleted: valid == nil})
+		}
+	})
+	collectors[1].Dump(func(gen int, path string, all, valid partition.Entry) {
+		if id, ok := all.(*doclist.ID); ok {
+			write(&DumpRecord{Collector: "doclist.ids", Partition: gen, Path: path, ID: id.Uint64(), Deleted: valid == nil})
+		}
+	})
+	return err
+}
+
+func dumpDocument(doc *doclist.Document) *DumpDocument {
+	device, inode := doc.Inode()
+	meta := doc.Metadata()
+	return &DumpDocument{
+		ID:          doc.ID(),
+		Path:        doc.Path(),
+		Length:      doc.Length(),
+		Hash:        doc.Hash(),
+		Fingerprint: doc.Fingerprint(),
+		ModTime:     doc.ModTime(),
+		Size:        doc.Size(),
+		Device:      device,
+		Inode:       inode,
+		MIME:        meta.MIME,
+		Title:       meta.Title,
+		Author:      meta.Author,
+		Created:     meta.Created,
+		Owner:       meta.Owner,
+	}
+}
+
+func (d *DumpDocument) document() *doclist.Document {
+	doc := doclist.NewDocument(d.ID, d.Path, d.Length)
+	doc.SetContent(d.Hash, d.Fingerprint)
+	doc.SetStat(d.ModTime, d.Size)
+	doc.SetInode(d.Device, d.Inode)
+	doc.SetMetadata(doclist.Metadata{MIME: d.MIME, Title: d.Title, Author: d.Author, Created: d.Created, Owner: d.Owner})
+	return doc
+}
+
+// LoadDump builds a new index at the indexpath from a dump written by Dump. Deleted
+// entries are left out, and the id list is rebuilt from the doclist rather than read
+func LoadDump(r io.Reader, indexpath string) (*Index, error) {
+	i := NewIndex(indexpath)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<30)
+	for line := 1; scanner.Scan(); line++ {
+		var record DumpRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		switch record.Collector {
+		case "postings":
+			for _, p := range record.Postings {
+				if !p.Deleted {
+					i.collector.Add(record.Term, &postingEntry{docID: p.ID, frequency: p.Frequency})
+				}
+			}
+		case "doclist":
+			if record.Document == nil {
+				return nil, fmt.Errorf("line %d: doclist record has no document", line)
+			}
+			if !record.Deleted {
+				i.docs.Add(record.Document.document())
+			}
+		case "doclist.ids":
+			// The id list is rebuilt as documents are added
+		default:
+			return nil, fmt.Errorf("line %d: unknown collector %q", line, record.Collector)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	i.ClearMemory()
+	return i, nil
+}
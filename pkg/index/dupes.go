@@ -0,0 +1,169 @@
+package index
+
+import (
+	"flash/pkg/index/doclist"
+	"flash/tools/simhash"
+	"sort"
+)
+
+// MinThreshold is the lowest similarity that near duplicates are found for. Documents
+// are compared when they share a band of their fingerprints, and below it the bands are
+// so narrow that nearly every pair of documents is compared
+const MinThreshold = 0.75
+
+// DuplicateGroup is a set of documents with the same or similar content
+type DuplicateGroup struct {
+	Paths      []string
+	Similarity float64
+	Exact      bool
+}
+
+// Duplicates returns groups of exact duplicates, followed by groups of near duplicates
+// whose fingerprints are at least as similar as the given threshold. Thresholds below
+// MinThreshold are raised to it
+func (i *Index) Duplicates(threshold float64) []DuplicateGroup {
+	// Group documents with identical content
+	exact := make(map[string][]*doclist.Document)
+	var keys []string
+	for _, doc := range i.docs.Documents() {
+		if len(doc.Hash()) == 0 || doc.Length() == 0 {
+			continue
+		}
+		key := string(doc.Hash())
+		if _, ok := exact[key]; !ok {
+			keys = append(keys, key)
+		}
+		exact[key] = append(exact[key], doc)
+	}
+
+	var groups []DuplicateGroup
+	reps := make([]*doclist.Document, len(keys))
+	for k, key := range keys {
+		docs := exact[key]
+		reps[k] = docs[0]
+		if len(docs) > 1 {
+			groups = append(groups, DuplicateGroup{Paths: paths(docs), Similarity: 1, Exact: true})
+		}
+	}
+
+	// Compare one representative of each exact group for near duplicates
+	for _, set := range nearDuplicates(reps, threshold) {
+		var docs []*doclist.Document
+		for _, r := range set.members {
+			docs = append(docs, exact[string(reps[r].Hash())]...)
+		}
+		groups = append(groups, DuplicateGroup{Paths: paths(docs), Similarity: set.similarity})
+	}
+
+	return groups
+}
+
+type nearSet struct {
+	members    []int
+	similarity float64
+}
+
+// nearDuplicates groups the documents whose fingerprints differ in at most
+// (1-threshold)*64 bits. The fingerprints are split into one more band than
+// the maximum distance, so any two candidates must share at least one band.
+func nearDuplicates(docs []*doclist.Document, threshold float64) []nearSet {
+	if threshold < MinThreshold {
+		threshold = MinThreshold
+	}
+	maxDist := int((1 - threshold) * simhash.Size)
+	if maxDist < 0 {
+		maxDist = 0
+	}
+	numBands := maxDist + 1
+	if numBands > simhash.Size {
+		numBands = simhash.Size
+	}
+	width := simhash.Size / numBands
+
+	parent := make([]int, len(docs))
+	for d := range parent {
+		parent[d] = d
+	}
+	var find func(int) int
+	find = func(d int) int {
+		if parent[d] != d {
+			parent[d] = find(parent[d])
+		}
+		return parent[d]
+	}
+
+	similarity := make(map[int]float64)
+	for band := 0; band < numBands; band++ {
+		shift := uint(band * width)
+		mask := uint64(1)<<uint(width) - 1
+		if band == numBands-1 {
+			mask = ^uint64(0) >> shift
+		}
+
+		buckets := make(map[uint64][]int)
+		for d, doc := range docs {
+			key := (doc.Fingerprint() >> shift) & mask
+			buckets[key] = append(buckets[key], d)
+		}
+
+		for _, bucket := range buckets {
+			for a := 0; a < len(bucket); a++ {
+				for b := a + 1; b < len(bucket); b++ {
+					fa, fb := docs[bucket[a]].Fingerprint(), docs[bucket[b]].Fingerprint()
+					if simhash.Distance(fa, fb) > maxDist {
+						continue
+					}
+
+					ra, rb := find(bucket[a]), find(bucket[b])
+					sim := simhash.Similarity(fa, fb)
+					if ra != rb {
+						parent[rb] = ra
+						sim = minSimilarity(sim, similarity, ra, rb)
+					} else if s, ok := similarity[ra]; ok && s < sim {
+						sim = s
+					}
+					similarity[ra] = sim
+				}
+			}
+		}
+	}
+
+	members := make(map[int][]int)
+	var roots []int
+	for d := range docs {
+		r := find(d)
+		if _, ok := members[r]; !ok {
+			roots = append(roots, r)
+		}
+		members[r] = append(members[r], d)
+	}
+
+	var sets []nearSet
+	for _, r := range roots {
+		if len(members[r]) > 1 {
+			sets = append(sets, nearSet{members: members[r], similarity: similarity[r]})
+		}
+	}
+
+	sort.Slice(sets, func(a, b int) bool { return sets[a].similarity > sets[b].similarity })
+	return sets
+}
+
+func minSimilarity(sim float64, similarity map[int]float64, roots ...int) float64 {
+	for _, r := range roots {
+		if s, ok := similarity[r]; ok && s < sim {
+			sim = s
+		}
+		delete(similarity, r)
+	}
+	return sim
+}
+
+func paths(docs []*doclist.Document) []string {
+	p := make([]string, len(docs))
+	for d := range docs {
+		p[d] = docs[d].Path()
+	}
+	sort.Strings(p)
+	return p
+}
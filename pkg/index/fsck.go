@@ -0,0 +1,144 @@
+package index
+
+import (
+	"bufio"
+	"flash/pkg/index/doclist"
+	"flash/pkg/index/partition"
+	"flash/pkg/index/postinglist"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Check validates the files of every collector in the index at the given path
+func Check(indexpath string) []partition.Problem {
+	problems := partition.Check(indexpath, "postings", postinglist.Validate)
+	return append(problems, doclist.Check(indexpath)...)
+}
+
+// Repair fixes the problems found by Check, it must be called before the index is loaded
+func Repair(indexpath string, problems []partition.Problem) error {
+	byExtension := make(map[string][]partition.Problem)
+	for _, p := range problems {
+		byExtension[p.Extension] = append(byExtension[p.Extension], p)
+	}
+
+	for ext, p := range byExtension {
+		if err := partition.Repair(indexpath, ext, p); err != nil {
+			return fmt.Errorf("could not repair %v: %w", ext, err)
+		}
+	}
+	return nil
+}
+
+// Verify checks that the doclist, id list and posting lists agree with each other. If
+// repair is true, the inconsistent entries are removed. The paths of documents which
+// must be reindexed are returned
+func (i *Index) Verify(repair bool) (problems []string, affected []string) {
+	problems, affected = i.docs.Verify(repair)
+
+	docs := make(map[uint64]*doclist.Document)
+	for _, doc := range i.docs.Documents() {
+		docs[doc.ID()] = doc
+	}
+
+	orphans := make(map[uint64]bool)
+	indexed := make(map[uint64]bool)
+	i.collector.Scan(func(term string, entries []partition.Entry) {
+		for _, e := range entries {
+			if l, ok := e.(*postinglist.List); ok {
+				for _, id := range l.GetDocs() {
+					if _, ok := docs[id]; ok {
+						indexed[id] = true
+					} else {
+						orphans[id] = true
+					}
+				}
+			}
+		}
+	})
+
+	var ids []uint64
+	for id := range orphans {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+	for _, id := range ids {
+		problems = append(problems, fmt.Sprintf("postings reference missing document %d", id))
+		if repair {
+			i.collector.Delete(fmt.Sprint(id))
+		}
+	}
+
+	for id, doc := range docs {
+		if doc.Length() > 0 && !indexed[id] {
+			problems = append(problems, fmt.Sprintf("%v has no postings", doc.Path()))
+			affected = append(affected, doc.Path())
+			if repair {
+				i.deleteDoc(doc)
+			}
+		}
+	}
+
+	if repair {
+		i.flush()
+	}
+	return problems, affected
+}
+
+// AffectedRoots returns the roots which contain any of the paths
+func AffectedRoots(paths, roots []string) []string {
+	var affected []string
+	for _, root := range roots {
+		for _, path := range paths {
+			if underRoot(path, []string{root}) {
+				affected = append(affected, root)
+				break
+			}
+		}
+	}
+	return affected
+}
+
+func getReindexPath(indexpath string) string {
+	return fmt.Sprintf("%v/reindex", indexpath)
+}
+
+// RequestReindex records roots which must be fully reindexed, which happens
+// the next time the daemon reconciles the index
+func RequestReindex(indexpath string, roots []string) error {
+	f, err := os.OpenFile(getReindexPath(indexpath), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, root := range roots {
+		fmt.Fprintln(f, root)
+	}
+	return f.Sync()
+}
+
+// pendingReindex returns the roots which were requested to be reindexed
+func (i *Index) pendingReindex() map[string]bool {
+	roots := make(map[string]bool)
+	f, err := os.Open(getReindexPath(i.dir))
+	if err != nil {
+		return roots
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if root := strings.TrimSpace(scanner.Text()); root != "" {
+			roots[root] = true
+		}
+	}
+	return roots
+}
+
+// reindex removes every document under the root, so that it's fully reindexed when readded
+func (i *Index) reindex(root string) {
+	i.Delete(root)
+}
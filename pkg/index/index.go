@@ -1,27 +1,34 @@
 package index
 
 import (
-	"flash/pkg/importer"
+	"errors"
 	"flash/pkg/index/doclist"
 	"flash/pkg/index/partition"
 	"flash/pkg/index/postinglist"
 	"flash/tools/blacklist"
+	"flash/tools/ignore"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
 	"sync"
-	"syscall"
+	"sync/atomic"
 
 	"github.com/spf13/viper"
 )
 
 // Index datastructure
 type Index struct {
+	name      string
 	dir       string
+	roots     []string
 	docs      *doclist.DocList
 	collector *partition.Collector
 	blacklist *blacklist.Blacklist
+	policies  map[string]Policy
+	budget    *partition.Budget
+	scan      scanState
+	changed   int64
+	closed    bool
 }
 
 // Info contains information about an index
@@ -30,8 +37,12 @@ type Info struct {
 	AvgLength float64
 }
 
-// NewIndex creates a new index
+// NewIndex creates a new index, configured by the current profile
 func NewIndex(indexpath string) *Index {
+	return newIndex(indexpath, CurrentProfile())
+}
+
+func newIndex(indexpath string, p Profile) *Index {
 	i := Index{
 		dir:       indexpath,
 		docs:      doclist.NewList(indexpath),
@@ -39,76 +50,151 @@ func NewIndex(indexpath string) *Index {
 		blacklist: &blacklist.Blacklist{},
 	}
 
-	i.blacklist.Add(viper.GetStringSlice("blacklist")...)
+	i.configure(p)
+	i.setMergePolicy()
+	i.setBudget()
 	i.createDir()
 	return &i
 }
 
-// Load opens the index at the indexpath
+// Load opens the index at the indexpath, configured by the current profile. It exits if
+// the index must be migrated before it can be used
 func Load(indexpath string) *Index {
+	i, err := load(indexpath, CurrentProfile())
+	if err != nil {
+		log.Fatalf("%v\nRun 'flash index migrate' to upgrade the index", err)
+	}
+	return i
+}
+
+// Open opens the index of the profile. Unlike Load, an error is returned if the index
+// must be migrated before it can be used
+func Open(p Profile) (*Index, error) {
+	return load(p.Path, p)
+}
+
+func load(indexpath string, p Profile) (*Index, error) {
 	i := &Index{
 		dir:       indexpath,
 		collector: partition.NewCollector(indexpath, "postings", NewPartition),
 		blacklist: &blacklist.Blacklist{},
 	}
 
-	i.blacklist.Add(viper.GetStringSlice("blacklist")...)
+	i.configure(p)
 	err := i.collector.Load()
-	if err != nil {
-		i = NewIndex(indexpath)
-	} else {
-		i.docs = doclist.Load(indexpath)
+	if err == nil {
+		i.docs, err = doclist.Load(indexpath)
 	}
-	return i
+
+	if errors.Is(err, partition.ErrIncompatible) {
+		return nil, err
+	} else if err != nil {
+		i = newIndex(indexpath, p)
+	}
+	i.setMergePolicy()
+	i.setBudget()
+	return i, nil
+}
+
+// setMergePolicy configures how the partitions of each collector are merged
+func (i *Index) setMergePolicy() {
+	policy := partition.MergePolicy{
+		Factor:         viper.GetInt("merge_factor"),
+		MaxSegmentSize: viper.GetInt64("max_segment_size"),
+	}
+	i.collector.SetMergePolicy(policy)
+	i.docs.SetMergePolicy(policy)
+}
+
+// setBudget shares a single memory budget between the collectors, so that the memory
+// partitions are written to disk once they use memory_budget bytes between them
+func (i *Index) setBudget() {
+	i.SetBudget(partition.NewBudget(viper.GetInt64("memory_budget")))
+}
+
+// SetBudget moves the collectors to the given budget, which may be shared with other indexes
+func (i *Index) SetBudget(b *partition.Budget) {
+	i.budget = b
+	i.collector.SetBudget(b)
+	i.docs.SetBudget(b)
+}
+
+// Add adds the given file or directory to the index. The text of each file is
+// extracted concurrently, and the lock is only held while it's inserted. Files
+// which haven't changed since they were last indexed are skipped
+func (i *Index) Add(path string, lock *sync.RWMutex) AddResult {
+	var indexed, skipped int64
+	ok := i.add(path, lock, func(ok bool) {
+		if ok {
+			atomic.AddInt64(&indexed, 1)
+		} else {
+			atomic.AddInt64(&skipped, 1)
+		}
+	})
+
+	res := AddResult{Indexed: int(indexed), Skipped: int(skipped)}
+	if ok && (res.Indexed > 1 || res.Skipped > 0) {
+		fmt.Printf("Added %v: %d indexed, %d unchanged\n", path, res.Indexed, res.Skipped)
+	}
+	return res
 }
 
-// Add adds the given file or directory to the index
-func (i *Index) Add(path string, lock *sync.RWMutex) {
+// add adds the file or directory, calling done after each file is processed.
+// False is returned if the path is excluded from the index
+func (i *Index) add(path string, lock *sync.RWMutex, done func(indexed bool)) bool {
 	if i.blacklist.Contains(path) {
-		return
+		return false
 	}
 
 	stat, err := os.Stat(path)
 	if err != nil {
 		fmt.Println(err)
-		return
+		return false
 	}
 
-	if len(stat.Name()) > 0 && stat.Name()[0:1] == "." {
-		return
+	policy := i.Policy(path)
+	if stat.IsDir() && !policy.AllowDir(path) || !stat.IsDir() && !policy.AllowFile(path, stat) {
+		return false
 	}
-
-	var id uint64
-	if sys, ok := stat.Sys().(*syscall.Stat_t); ok {
-		id = sys.Ino
+	if ignore.NewTree(policy.Root(path)).Ignored(path, stat.IsDir()) {
+		return false
 	}
 
-	if !stat.IsDir() {
-		lock.Lock()
-		if _, ok := i.docs.FetchPath(path); ok {
-			fmt.Println(path, "already in the index, removing and readding")
-			i.Delete(path)
+	paths := make(chan string)
+	go func() {
+		if stat.IsDir() {
+			i.addDir(path, paths)
+		} else {
+			paths <- path
 		}
+		close(paths)
+	}()
 
-		textChannel := importer.GetTextChannel(path)
-		var offset uint32
-		for term := range textChannel {
-			i.collector.Add(term, &postingEntry{id})
-			offset++
-		}
-		i.docs.Add(id, path, offset)
-		lock.Unlock()
-	} else {
-		i.addDir(path, lock)
-	}
+	i.addFiles(paths, lock, done)
+	return true
 }
 
-// Delete removes the given file from the index
+// Delete removes the given file, or every file under the given directory, from the index
 func (i *Index) Delete(path string) {
-	for _, id := range i.docs.GetIDs(path) {
+	for docPath, id := range i.docs.GetSubtree(path) {
 		i.collector.Delete(id.String())
-		i.docs.Delete(id.String(), path)
+		i.docs.Delete(id.String(), docPath)
 	}
+	i.flush()
+}
+
+// deleteDoc removes a single document from the index
+func (i *Index) deleteDoc(doc *doclist.Document) {
+	id := fmt.Sprint(doc.ID())
+	i.collector.Delete(id)
+	i.docs.Delete(id, doc.Path())
+}
+
+// flush journals any buffered changes, so that they can be recovered after a crash
+func (i *Index) flush() {
+	i.collector.Flush()
+	i.docs.Flush()
+	i.touch()
 }
 
 // GetPostingReaders returns a list of posting readers for the given term
@@ -140,27 +226,28 @@ func (i *Index) GetDocInfo(id uint64) (path string, length uint32, ok bool) {
 	return "", 0, false
 }
 
-func (i *Index) addDir(dir string, lock *sync.RWMutex) {
-	visit := func(path string, info os.FileInfo, err error) error {
-		if info == nil {
-			return nil
-		}
+// GetDocument returns the document for the file at the given path
+func (i *Index) GetDocument(path string) (*doclist.Document, bool) {
+	return i.docs.FetchPath(path)
+}
 
-		if len(info.Name()) > 0 && info.Name()[0:1] == "." {
-			if info.IsDir() {
-				return filepath.SkipDir
-			}
+// addDir sends the files under the directory which are allowed by its policy,
+// skipping those excluded by ignore files
+func (i *Index) addDir(dir string, paths chan<- string) {
+	policy := i.Policy(dir)
+	visit := func(path string, info os.FileInfo, err error) error {
+		if info == nil || info.IsDir() {
 			return nil
 		}
 
-		if info.Mode().IsRegular() {
-			i.Add(path, lock)
+		if policy.AllowFile(path, info) && !i.blacklist.Contains(path) {
+			paths <- path
 		}
 
 		return nil
 	}
 
-	err := filepath.Walk(dir, visit)
+	err := policy.Walk(dir, visit)
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -172,6 +259,12 @@ func (i *Index) ClearMemory() {
 	i.docs.ClearMemory()
 }
 
+// Close waits for any background merges and writes the index to disk, after which it must not be used
+func (i *Index) Close() {
+	i.ClearMemory()
+	i.closed = true
+}
+
 // GetPath returns the path of the index
 func (i *Index) GetPath() string {
 	return i.dir
@@ -187,9 +280,63 @@ func (i *Index) createDir() {
 	}
 }
 
-// Blacklist blacklists the given pattern for the index
-func (i *Index) Blacklist(pattern string) error {
-	return i.blacklist.Add(pattern)
+// Blacklist blacklists the given pattern for the index, and removes the documents
+// it matches. The number of documents removed is returned
+func (i *Index) Blacklist(pattern string) (int, error) {
+	p, err := blacklist.Compile(pattern)
+	if err != nil {
+		return 0, err
+	}
+	i.blacklist.Add(pattern)
+
+	removed := 0
+	for _, doc := range i.docs.Documents() {
+		if p.Match(doc.Path()) {
+			i.deleteDoc(doc)
+			removed++
+		}
+	}
+	i.flush()
+	return removed, nil
+}
+
+// AddMatching adds the files under the roots which match the pattern, used to index
+// the files which a pattern excluded once it has been removed from the blacklist
+func (i *Index) AddMatching(pattern string, roots []string, lock *sync.RWMutex) AddResult {
+	p, err := blacklist.Compile(pattern)
+	if err != nil {
+		return AddResult{}
+	}
+
+	all := make(chan string)
+	go func() {
+		for _, root := range roots {
+			i.addDir(root, all)
+		}
+		close(all)
+	}()
+	paths := make(chan string)
+	go func() {
+		for path := range all {
+			if p.Match(path) {
+				paths <- path
+			}
+		}
+		close(paths)
+	}()
+
+	var res AddResult
+	var mu sync.Mutex
+	i.addFiles(paths, lock, func(indexed bool) {
+		mu.Lock()
+		if indexed {
+			res.Indexed++
+		} else {
+			res.Skipped++
+		}
+		mu.Unlock()
+	})
+	return res
 }
 
 // RemoveBlacklist removes the given patern from the index
@@ -1,12 +1,22 @@
 package index
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"flash/pkg/index/doclist"
+	"flash/pkg/index/partition"
 	"flash/tools/tika"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"sync"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -287,3 +297,1382 @@ func TestGetPostingReaders(t *testing.T) {
 
 // 	for i := 0; i <
 // }
+
+func writeLegacy(t *testing.T, path string, values ...interface{}) {
+	buf := new(bytes.Buffer)
+	for _, v := range values {
+		binary.Write(buf, binary.LittleEndian, v)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeLegacyEntry(t *testing.T, path, key string, values ...interface{}) {
+	data := new(bytes.Buffer)
+	for _, v := range values {
+		binary.Write(data, binary.LittleEndian, v)
+	}
+	writeLegacy(t, path, uint32(len(key)), []byte(key), uint32(data.Len()), data.Bytes())
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	os.MkdirAll(indexpath, 0755)
+	defer os.RemoveAll(indexpath)
+
+	path := "/docs/hello_world.txt"
+	for _, ext := range []string{"postings", "doclist", "doclist.ids"} {
+		writeLegacy(t, fmt.Sprintf("%v/%v.info", indexpath, ext), uint32(1))
+		writeLegacy(t, fmt.Sprintf("%v/part_1.%v.info", indexpath, ext), uint32(0), uint32(0))
+	}
+	writeLegacyEntry(t, indexpath+"/part_1.postings", "hello", uint32(1), uint64(5), uint32(2))
+	writeLegacy(t, indexpath+"/postings.info", uint32(1), uint32(0))
+	writeLegacy(t, indexpath+"/temp.postings.info", uint32(0), uint32(0))
+	writeLegacyEntry(t, indexpath+"/temp.postings", "world", uint32(1), uint64(5), uint32(1))
+	writeLegacyEntry(t, indexpath+"/part_1.doclist", "5", uint64(5), uint32(3), uint32(len(path)), []byte(path))
+	writeLegacy(t, indexpath+"/doclist.info", uint32(1), uint32(0))
+	writeLegacy(t, indexpath+"/temp.doclist.info", uint32(0), uint32(0))
+	writeLegacyEntry(t, indexpath+"/temp.doclist", "6", uint64(6), uint32(1), uint32(len(path)+1), []byte(path+"~"))
+	writeLegacyEntry(t, indexpath+"/part_1.doclist.ids", path, uint64(5))
+	writeLegacy(t, indexpath+"/doclist.stats", uint32(1), float64(3))
+
+	from, err := Migrate(indexpath)
+	if err != nil || from != 0 {
+		t.Fatal(from, err)
+	}
+
+	index := Load(indexpath)
+	readers := index.GetPostingReaders("hello")
+	if len(readers) != 1 || !readers[0].Read() {
+		t.FailNow()
+	}
+	id, freq := readers[0].Data()
+	docPath, length, ok := index.GetDocInfo(id)
+	if id != 5 || freq != 2 || !ok || docPath != path || length != 3 || index.GetInfo().NumDocs != 1 {
+		t.Fail()
+	}
+
+	readers = index.GetPostingReaders("world")
+	if len(readers) != 1 || !readers[0].Read() {
+		t.FailNow()
+	}
+
+	// Documents in the journal are rewritten too
+	if docPath, length, ok := index.GetDocInfo(6); !ok || docPath != path+"~" || length != 1 {
+		t.Errorf("journaled document was not migrated: %v %v %v", docPath, length, ok)
+	}
+
+	if from, err = Migrate(indexpath); err != nil || from != partition.Version {
+		t.Fail()
+	}
+}
+
+func TestRecoverJournal(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	index := NewIndex(indexpath)
+	index.collector.Add("hello", &postingEntry{docID: 7, frequency: 1})
+	index.collector.Add("hello", &postingEntry{docID: 7, frequency: 1})
+	index.docs.Add(doclist.NewDocument(7, "/docs/hello.txt", 2))
+	index.collector.Add("world", &postingEntry{docID: 8, frequency: 1})
+	index.docs.Add(doclist.NewDocument(8, "/docs/world.txt", 1))
+	index.flush()
+	index.Delete("/docs/world.txt")
+
+	// Load without clearing memory, as if the process was killed
+	index = Load(indexpath)
+	info := index.GetInfo()
+	if info.NumDocs != 1 || info.AvgLength != 2 {
+		t.Fatal(info)
+	}
+
+	readers := index.GetPostingReaders("hello")
+	if len(readers) != 1 || !readers[0].Read() {
+		t.FailNow()
+	}
+	if id, freq := readers[0].Data(); id != 7 || freq != 2 {
+		t.Fail()
+	}
+
+	if _, _, ok := index.GetDocInfo(8); ok {
+		t.Fail()
+	}
+}
+
+func TestRemoveOrphans(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	index := NewIndex(indexpath)
+	index.collector.Add("hello", &postingEntry{docID: 7, frequency: 1})
+	index.ClearMemory()
+
+	orphans := []string{"part_3.postings", "part_3.postings.dict", "temp.postings", "part_1.postings.temp", "postings.manifest.temp"}
+	for _, name := range orphans {
+		ioutil.WriteFile(indexpath+"/"+name, []byte{}, 0644)
+	}
+
+	index = Load(indexpath)
+	for _, name := range orphans {
+		if _, err := os.Stat(indexpath + "/" + name); err == nil {
+			t.Error(name)
+		}
+	}
+
+	if len(index.GetPostingReaders("hello")) != 1 {
+		t.Fail()
+	}
+}
+
+func TestSkipUnchanged(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	dir, _ := ioutil.TempDir("", "flash")
+	defer os.RemoveAll(dir)
+	file := dir + "/hello.txt"
+	ioutil.WriteFile(file, []byte("hello world"), 0644)
+	stat, _ := os.Stat(file)
+	hash, _ := hashFile(file)
+
+	index := NewIndex(indexpath)
+	doc := doclist.NewDocument(1, file, 2)
+	doc.SetContent(hash, 0)
+	doc.SetStat(stat.ModTime().UnixNano(), stat.Size())
+	index.docs.Add(doc)
+
+	lock := &sync.RWMutex{}
+	if res, err := index.extract(file, lock); res != nil || err != nil {
+		t.Fatal("unchanged file was extracted")
+	}
+
+	// Touching the file shouldn't cause it to be reindexed
+	later := stat.ModTime().Add(time.Hour)
+	os.Chtimes(file, later, later)
+	if res, err := index.extract(file, lock); res != nil || err != nil {
+		t.Fatal("touched file was extracted")
+	}
+
+	// The new modification time is kept, so the file isn't hashed again
+	touched, _ := index.docs.FetchPath(file)
+	if touched.ModTime() != later.UnixNano() || touched.Size() != stat.Size() {
+		t.Fatal("modification time of touched file wasn't updated")
+	}
+	ioutil.WriteFile(file, []byte("hello there"), 0644)
+	os.Chtimes(file, later, later)
+	if res, err := index.extract(file, lock); res != nil || err != nil {
+		t.Fatal("touched file was hashed again")
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	dir, _ := ioutil.TempDir("", "flash")
+	defer os.RemoveAll(dir)
+	file := dir + "/hello.txt"
+	ioutil.WriteFile(file, []byte("hello world"), 0644)
+	stat, _ := os.Stat(file)
+
+	index := NewIndex(indexpath)
+	doc := doclist.NewDocument(1, file, 2)
+	doc.SetStat(stat.ModTime().UnixNano(), stat.Size())
+	index.docs.Add(doc)
+	index.docs.Add(doclist.NewDocument(2, dir+"/removed.txt", 1))
+	index.docs.Add(doclist.NewDocument(3, "/elsewhere/file.txt", 1))
+
+	index.Reconcile([]string{dir + "/"}, &sync.RWMutex{})
+	progress := index.ScanProgress()
+	if !progress.Finished || progress.Skipped != 1 || progress.Indexed != 0 || progress.Deleted != 2 {
+		t.Fatal(progress)
+	}
+
+	if _, ok := index.docs.FetchPath(file); !ok {
+		t.Error("unchanged file was removed")
+	}
+	if index.GetInfo().NumDocs != 1 {
+		t.Error(index.GetInfo())
+	}
+}
+
+// extractedFile stats the file and gives it a single term, without requiring tika
+func extractedFile(path string) *extracted {
+	stat, _ := os.Stat(path)
+	sys := stat.Sys().(*syscall.Stat_t)
+	return &extracted{
+		device:  uint64(sys.Dev),
+		inode:   sys.Ino,
+		path:    path,
+		terms:   map[string]uint32{"hello": 1},
+		length:  1,
+		modTime: stat.ModTime().UnixNano(),
+		size:    stat.Size(),
+	}
+}
+
+func TestStableIDs(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	dir, _ := ioutil.TempDir("", "flash")
+	defer os.RemoveAll(dir)
+	file := dir + "/hello.txt"
+	ioutil.WriteFile(file, []byte("hello"), 0644)
+
+	index := NewIndex(indexpath)
+	index.insert(extractedFile(file))
+	doc, _ := index.docs.FetchPath(file)
+
+	// Updating the file keeps its id
+	ioutil.WriteFile(file, []byte("hello again"), 0644)
+	index.insert(extractedFile(file))
+	if updated, ok := index.docs.FetchPath(file); !ok || updated.ID() != doc.ID() {
+		t.Fatal("id changed after update")
+	}
+
+	// Renaming the file moves the document without reindexing it
+	renamed := dir + "/renamed.txt"
+	os.Rename(file, renamed)
+	if res, err := index.extract(renamed, &sync.RWMutex{}); res != nil || err != nil {
+		t.Fatal("renamed file was extracted")
+	}
+	if moved, ok := index.docs.FetchPath(renamed); !ok || moved.ID() != doc.ID() {
+		t.Fatal("id changed after rename")
+	}
+	if _, ok := index.docs.FetchPath(file); ok {
+		t.Error("old path still indexed")
+	}
+
+	// Hard links are indexed separately
+	link := dir + "/link.txt"
+	os.Link(renamed, link)
+	index.insert(extractedFile(link))
+	if linked, ok := index.docs.FetchPath(link); !ok || linked.ID() == doc.ID() {
+		t.Error("hard link shares an id")
+	}
+
+	if index.GetInfo().NumDocs != 2 {
+		t.Error(index.GetInfo())
+	}
+	if r := index.GetPostingReaders("hello"); len(r) != 1 || r[0].NumDocs() != 2 {
+		t.Error("expected postings for both links")
+	}
+}
+
+func TestDocumentMetadata(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	dir, _ := ioutil.TempDir("", "flash")
+	defer os.RemoveAll(dir)
+	file := dir + "/report.pdf"
+	ioutil.WriteFile(file, []byte("hello"), 0644)
+
+	meta := doclist.Metadata{MIME: "application/pdf", Title: "Report", Author: "Andy", Created: 1234, Owner: 1000}
+	doc := extractedFile(file)
+	doc.meta = meta
+
+	index := NewIndex(indexpath)
+	index.insert(doc)
+	index.ClearMemory()
+
+	index = Load(indexpath)
+	stored, ok := index.GetDocument(file)
+	if !ok || stored.Metadata() != meta || stored.Size() != 5 {
+		t.Fatal(stored)
+	}
+}
+
+func TestStats(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	dir, _ := ioutil.TempDir("", "flash")
+	defer os.RemoveAll(dir)
+
+	index := NewIndex(indexpath)
+	for n, name := range []string{"a.txt", "b.txt"} {
+		file := dir + "/" + name
+		ioutil.WriteFile(file, []byte("hello"), 0644)
+		doc := extractedFile(file)
+		doc.terms = map[string]uint32{"hello": 1, name: 1}
+		if n == 0 {
+			doc.meta.MIME = "text/plain"
+		}
+		index.insert(doc)
+	}
+
+	stats := index.Stats(1)
+	if stats.NumDocs != 2 || stats.Vocabulary != 3 {
+		t.Fatal(stats)
+	}
+	if len(stats.TopTerms) != 1 || stats.TopTerms[0] != (TermFrequency{"hello", 2}) {
+		t.Error(stats.TopTerms)
+	}
+	if len(stats.FileTypes) != 1 || stats.FileTypes[0] != (FileTypeStats{"text/plain", 2, 10}) {
+		t.Error(stats.FileTypes)
+	}
+	if len(stats.Collectors) != 4 || stats.Collectors[0].Extension != "postings" {
+		t.Error(stats.Collectors)
+	}
+}
+
+func TestFsck(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	dir, _ := ioutil.TempDir("", "flash")
+	defer os.RemoveAll(dir)
+
+	index := NewIndex(indexpath)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		file := dir + "/" + name
+		ioutil.WriteFile(file, []byte("hello"), 0644)
+		index.insert(extractedFile(file))
+	}
+
+	// Postings of a document missing from the doclist are removed
+	doc, _ := index.docs.FetchPath(dir + "/a.txt")
+	index.docs.Delete(fmt.Sprint(doc.ID()), doc.Path())
+	if problems, _ := index.Verify(false); len(problems) != 1 {
+		t.Fatal(problems)
+	}
+	index.Verify(true)
+	if problems, _ := index.Verify(false); len(problems) != 0 {
+		t.Fatal(problems)
+	}
+	if r := index.GetPostingReaders("hello"); len(r) != 1 || r[0].NumDocs() != 1 {
+		t.Error("expected postings for the remaining document")
+	}
+	index.ClearMemory()
+
+	if problems := Check(indexpath); len(problems) != 0 {
+		t.Fatal(problems)
+	}
+
+	// A broken journal is removed, losing the postings it held
+	ioutil.WriteFile(indexpath+"/postings.wal", []byte("broken"), 0644)
+	problems := Check(indexpath)
+	if len(problems) != 1 || problems[0].Kind != partition.BrokenJournal || !problems[0].LosesData() {
+		t.Fatal(problems)
+	}
+	if err := Repair(indexpath, problems); err != nil {
+		t.Fatal(err)
+	}
+	if problems := Check(indexpath); len(problems) != 0 {
+		t.Fatal(problems)
+	}
+
+	// Documents without postings are removed, and their roots reindexed
+	index = Load(indexpath)
+	_, affected := index.Verify(true)
+	roots := AffectedRoots(affected, []string{"/elsewhere/", dir + "/"})
+	if len(roots) != 1 || roots[0] != dir+"/" {
+		t.Fatal(roots)
+	}
+	if index.GetInfo().NumDocs != 0 {
+		t.Fatal(index.GetInfo())
+	}
+
+	RequestReindex(indexpath, roots)
+	index.Reconcile(roots, &sync.RWMutex{})
+	if _, err := os.Stat(indexpath + "/reindex"); !os.IsNotExist(err) {
+		t.Error("reindex request was not cleared")
+	}
+}
+
+func TestConcurrentLookups(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	os.MkdirAll(indexpath, 0755)
+	defer os.RemoveAll(indexpath)
+
+	// Write a partition to disk using the legacy format, so that lookups use its mapping
+	path := "/docs/hello_world.txt"
+	for _, ext := range []string{"postings", "doclist", "doclist.ids"} {
+		writeLegacy(t, fmt.Sprintf("%v/%v.info", indexpath, ext), uint32(1))
+		writeLegacy(t, fmt.Sprintf("%v/part_1.%v.info", indexpath, ext), uint32(0), uint32(0))
+	}
+	writeLegacyEntry(t, indexpath+"/part_1.postings", "hello", uint32(1), uint64(5), uint32(2))
+	writeLegacyEntry(t, indexpath+"/part_1.doclist", "5", uint64(5), uint32(3), uint32(len(path)), []byte(path))
+	writeLegacyEntry(t, indexpath+"/part_1.doclist.ids", path, uint64(5))
+	writeLegacy(t, indexpath+"/doclist.stats", uint32(1), float64(3))
+	if _, err := Migrate(indexpath); err != nil {
+		t.Fatal(err)
+	}
+
+	index := Load(indexpath)
+	var wg sync.WaitGroup
+	errs := make(chan string, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				readers := index.GetPostingReaders("hello")
+				if len(readers) != 1 || !readers[0].Read() {
+					errs <- "missing postings"
+					return
+				}
+				if docPath, _, ok := index.GetDocInfo(5); !ok || docPath != path {
+					errs <- "missing document"
+					return
+				}
+				if len(index.GetPostingReaders("missing")) != 0 {
+					errs <- "found missing term"
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestConcurrentAdd(t *testing.T) {
+	setup()
+	viper.Set("workers", 4)
+	defer viper.Set("workers", 0)
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	dir, _ := ioutil.TempDir("", "flash")
+	defer os.RemoveAll(dir)
+	var files []string
+	for n := 0; n < 50; n++ {
+		file := fmt.Sprintf("%v/%03d.txt", dir, n)
+		ioutil.WriteFile(file, []byte(fmt.Sprint("file ", n)), 0644)
+		files = append(files, file)
+	}
+	old := dir + "/old.txt"
+	ioutil.WriteFile(old, nil, 0644)
+
+	// Reading from a pipe blocks until it's written to, which holds up one worker
+	slow := dir + "/slow.txt"
+	if err := syscall.Mkfifo(slow, 0644); err != nil {
+		t.Skip("can't create a pipe:", err)
+	}
+
+	index := NewIndex(indexpath)
+	index.insert(extractedFile(old))
+
+	lock := &sync.RWMutex{}
+	paths := make(chan string, len(files)+1)
+	paths <- slow
+	for _, file := range files {
+		paths <- file
+	}
+	close(paths)
+
+	indexed := make(chan bool, len(files)+1)
+	finished := make(chan bool)
+	go func() {
+		index.addFiles(paths, lock, func(ok bool) { indexed <- ok })
+		close(finished)
+	}()
+
+	// The other workers carry on while one is extracting
+	timeout := time.After(10 * time.Second)
+	for range files {
+		select {
+		case ok := <-indexed:
+			if !ok {
+				t.Fatal("a new file was skipped")
+			}
+		case <-timeout:
+			t.Fatal("files weren't indexed while a worker was extracting")
+		}
+	}
+
+	// Searches and deletes don't wait for the extraction
+	searched := make(chan bool)
+	go func() {
+		lock.RLock()
+		found := len(index.GetPostingReaders("hello")) > 0
+		lock.RUnlock()
+		lock.Lock()
+		index.Delete(old)
+		lock.Unlock()
+		searched <- found
+	}()
+	select {
+	case found := <-searched:
+		if !found {
+			t.Error("expected to find the postings of a file added before")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("search and delete were blocked by extraction")
+	}
+
+	// The pipe is read once to hash it and again to extract its text, and each read
+	// ends when there's no writer left
+	for done := false; !done; {
+		if w, err := os.OpenFile(slow, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+			w.Close()
+		}
+		select {
+		case <-finished:
+			done = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	close(indexed)
+	if ok := <-indexed; !ok {
+		t.Error("the slow file was skipped")
+	}
+
+	// Each file is inserted exactly once, under its own id
+	ids := make(map[uint64]bool)
+	for _, file := range append(files, slow) {
+		doc, ok := index.docs.FetchPath(file)
+		if !ok {
+			t.Fatalf("%v wasn't indexed", file)
+		}
+		ids[doc.ID()] = true
+	}
+	if len(ids) != len(files)+1 || index.GetInfo().NumDocs != uint32(len(files)+1) {
+		t.Errorf("expected %d documents, found %d with %d ids", len(files)+1, index.GetInfo().NumDocs, len(ids))
+	}
+	if _, ok := index.docs.FetchPath(old); ok {
+		t.Error("deleted file was still indexed")
+	}
+}
+
+func TestBackgroundMerge(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	index := NewIndex(indexpath)
+	index.collector.SetMergePolicy(partition.MergePolicy{Factor: 2})
+	for id := uint64(1); id <= 4; id++ {
+		index.collector.Add("hello", &postingEntry{docID: id, frequency: 1})
+		index.collector.Add(fmt.Sprint("term", id), &postingEntry{docID: id, frequency: 1})
+		index.collector.FlushMemory()
+
+		// Searches use the existing partitions while the merge runs
+		if r := index.GetPostingReaders("hello"); len(r) == 0 {
+			t.Fatal("no postings during merge")
+		}
+	}
+
+	// Deletes made while merging are applied to the merged partition
+	index.collector.Delete("2")
+	index.ClearMemory()
+
+	// At least one merge has completed, others may have been skipped while it was running
+	if parts := index.collector.Stats().Partitions; len(parts)-1 >= 4 {
+		t.Errorf("expected partitions to be merged, found %d", len(parts)-1)
+	}
+
+	index = Load(indexpath)
+	var ids []uint64
+	for _, r := range index.GetPostingReaders("hello") {
+		for r.Read() {
+			id, _ := r.Data()
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) != 3 {
+		t.Fatal(ids)
+	}
+	for _, id := range ids {
+		if id == 2 {
+			t.Fatal("deleted document found after merge")
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	index := NewIndex(indexpath)
+	for id := uint64(1); id <= 3; id++ {
+		index.collector.Add("hello", &postingEntry{docID: id, frequency: 1})
+		index.collector.Add(fmt.Sprint("term", id), &postingEntry{docID: id, frequency: 1})
+	}
+	index.collector.FlushMemory()
+	index.collector.Delete("1")
+	index.collector.Delete("2")
+
+	lock := &sync.RWMutex{}
+	if res := index.Compact(lock, 3); res.Partitions != 0 {
+		t.Fatal("compacted partition with too few tombstones")
+	}
+	res := index.Compact(lock, 1)
+	if res.Partitions != 1 || res.Reclaimed <= 0 {
+		t.Fatal(res)
+	}
+
+	parts := index.collector.Stats().Partitions
+	if len(parts) != 2 || parts[0].Tombstones != 0 || parts[0].Keys != 2 {
+		t.Fatal(parts)
+	}
+
+	index.ClearMemory()
+	index = Load(indexpath)
+	readers := index.GetPostingReaders("hello")
+	if len(readers) != 1 || readers[0].NumDocs() != 1 {
+		t.Fatal("expected only the remaining document")
+	}
+	if len(index.GetPostingReaders("term1")) != 0 {
+		t.Error("postings of a deleted document were kept")
+	}
+
+	// A partition whose documents have all been deleted is removed
+	index.collector.Delete("3")
+	if res := index.Compact(lock, 1); res.Partitions != 1 {
+		t.Fatal(res)
+	}
+	if parts := index.collector.Stats().Partitions; len(parts) != 1 {
+		t.Errorf("expected only the memory partition, found %v", parts)
+	}
+}
+
+func TestCompactDuringMerge(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	index := NewIndex(indexpath)
+	index.collector.SetMergePolicy(partition.MergePolicy{Factor: 2})
+	for id := uint64(1); id <= 2; id++ {
+		index.collector.Add("hello", &postingEntry{docID: id, frequency: 1})
+	}
+	index.collector.FlushMemory()
+	index.collector.Delete("1")
+
+	// Flushing a second partition starts merging both, which drops the tombstoned document
+	index.collector.Add("hello", &postingEntry{docID: 3, frequency: 1})
+	index.collector.FlushMemory()
+
+	lock := &sync.RWMutex{}
+	if res := index.Compact(lock, 1); res.Partitions != 0 || res.Reclaimed != 0 {
+		t.Errorf("counted a merge as a compaction: %+v", res)
+	}
+	parts := index.collector.Stats().Partitions
+	if len(parts) != 2 || parts[0].Tombstones != 0 || parts[0].Keys != 1 {
+		t.Fatalf("expected the merged partition and the memory partition, found %v", parts)
+	}
+	if r := index.GetPostingReaders("hello"); len(r) != 1 || r[0].NumDocs() != 2 {
+		t.Error("expected the postings of the remaining documents")
+	}
+}
+
+func TestTombstones(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	// Each document is flushed into a partition of its own
+	index := NewIndex(indexpath)
+	index.collector.SetMergePolicy(partition.MergePolicy{Factor: 10})
+	index.docs.SetMergePolicy(partition.MergePolicy{Factor: 10})
+	for id := uint64(1); id <= 2; id++ {
+		index.collector.Add("hello", &postingEntry{docID: id, frequency: 1})
+		index.docs.Add(doclist.NewDocument(id, fmt.Sprintf("/docs/%d.txt", id), 1))
+		index.collector.FlushMemory()
+		for _, c := range index.docs.Collectors() {
+			c.FlushMemory()
+		}
+	}
+
+	// Only the partitions which hold the document are tombstoned
+	index.Delete("/docs/1.txt")
+	check := func(stats partition.CollectorStats) {
+		parts := stats.Partitions
+		if len(parts) != 3 || parts[0].Tombstones != 1 || parts[1].Tombstones != 0 {
+			t.Errorf("expected a tombstone in only the first partition of %v, found %v", stats.Extension, parts)
+		}
+	}
+	check(index.collector.Stats())
+	check(index.docs.Collectors()[0].Stats())
+
+	// The documents held by each partition are kept when it's reloaded
+	index.ClearMemory()
+	index = Load(indexpath)
+	index.Delete("/docs/2.txt")
+	if parts := index.collector.Stats().Partitions; parts[0].Tombstones != 1 || parts[1].Tombstones != 1 {
+		t.Errorf("expected a tombstone in each partition, found %v", parts)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	dir, _ := ioutil.TempDir("", "flash")
+	defer os.RemoveAll(dir)
+	file := dir + "/hello.txt"
+	ioutil.WriteFile(file, []byte("hello"), 0644)
+
+	index := NewIndex(indexpath)
+	index.insert(extractedFile(file))
+
+	buf := new(bytes.Buffer)
+	config := BackupConfig{Dirs: []string{dir}, Blacklist: []string{`\.git`}}
+	if err := index.Export(buf, config); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := dir + "/restored"
+	os.MkdirAll(restored, 0755)
+	ioutil.WriteFile(restored+"/stale", []byte{}, 0644)
+	imported, err := Import(bytes.NewReader(buf.Bytes()), restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported.Dirs) != 1 || imported.Dirs[0] != dir || len(imported.Blacklist) != 1 {
+		t.Error(imported)
+	}
+	if _, err := os.Stat(restored + "/stale"); !os.IsNotExist(err) {
+		t.Error("existing index was not replaced")
+	}
+
+	index = Load(restored)
+	if _, ok := index.GetDocument(file); !ok || len(index.GetPostingReaders("hello")) != 1 {
+		t.Fatal("document missing from imported index")
+	}
+
+	if _, err := Import(bytes.NewReader([]byte("not an archive")), restored); err == nil {
+		t.Error("expected an error importing an invalid archive")
+	}
+	if _, ok := Load(restored).GetDocument(file); !ok {
+		t.Error("failed import replaced the index")
+	}
+}
+
+func TestDumpLoad(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	dir, _ := ioutil.TempDir("", "flash")
+	defer os.RemoveAll(dir)
+
+	index := NewIndex(indexpath)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		file := dir + "/" + name
+		ioutil.WriteFile(file, []byte("hello"), 0644)
+		doc := extractedFile(file)
+		doc.meta.Title = name
+		index.insert(doc)
+	}
+
+	// Write the partitions to disk so that the deleted document is kept as a tombstone
+	for _, c := range append(index.docs.Collectors(), index.collector) {
+		c.FlushMemory()
+	}
+	index.Delete(dir + "/a.txt")
+
+	buf := new(bytes.Buffer)
+	if err := index.Dump(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var records []DumpRecord
+	deleted := 0
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var r DumpRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			t.Fatal(err)
+		}
+		if r.Deleted {
+			deleted++
+		}
+		for _, p := range r.Postings {
+			if p.Deleted {
+				deleted++
+			}
+		}
+		records = append(records, r)
+	}
+	if len(records) != 5 || deleted != 3 {
+		t.Fatal(buf.String())
+	}
+
+	loaded, err := LoadDump(bytes.NewReader(buf.Bytes()), dir+"/loaded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, ok := loaded.GetDocument(dir + "/b.txt")
+	if !ok || doc.Metadata().Title != "b.txt" || loaded.GetInfo().NumDocs != 1 {
+		t.Fatal("document missing from loaded index")
+	}
+	if _, ok := loaded.GetDocument(dir + "/a.txt"); ok {
+		t.Error("deleted document was loaded")
+	}
+	if r := loaded.GetPostingReaders("hello"); len(r) != 1 || r[0].NumDocs() != 1 {
+		t.Error("expected postings for the remaining document")
+	}
+	if id := loaded.docs.NewID(); id <= doc.ID() {
+		t.Error("loaded index reuses ids", id)
+	}
+}
+
+func TestDeleteSubtree(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	index := NewIndex(indexpath)
+	paths := []string{"/docs/a/x.txt", "/docs/a.txt", "/docs/ab.txt", "/docs/a/b/y.txt", "/docs/b/a/z.txt"}
+	for n, path := range paths {
+		id := uint64(n + 1)
+		index.collector.Add("hello", &postingEntry{docID: id, frequency: 1})
+		index.docs.Add(doclist.NewDocument(id, path, 1))
+
+		// Keep some of the documents on disk, so that both kinds of partition are searched
+		if n == 2 {
+			for _, c := range append(index.docs.Collectors(), index.collector) {
+				c.FlushMemory()
+			}
+		}
+	}
+
+	index.Delete("/docs/a/")
+	for n, path := range paths {
+		_, ok := index.docs.FetchPath(path)
+		if removed := n == 0 || n == 3; ok == removed {
+			t.Errorf("%v: indexed %v", path, ok)
+		}
+	}
+	if info := index.GetInfo(); info.NumDocs != 3 {
+		t.Error(info.NumDocs)
+	}
+}
+
+func TestMemoryBudget(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	index := NewIndex(indexpath)
+	index.budget.SetLimit(16 << 10)
+	for id := uint64(1); id <= 200; id++ {
+		for n := 0; n < 10; n++ {
+			index.collector.Add(fmt.Sprint("term", n, "-", id%20), &postingEntry{docID: id, frequency: 1})
+		}
+		index.docs.Add(doclist.NewDocument(id, fmt.Sprint("/docs/", id, ".txt"), 10))
+
+		if used := index.budget.Used(); used > index.budget.Limit() {
+			t.Fatalf("%d bytes used, over the budget of %d", used, index.budget.Limit())
+		}
+	}
+
+	stats := index.Stats(0)
+	if stats.Memory == 0 || stats.Budget != 16<<10 {
+		t.Error(stats.Memory, stats.Budget)
+	}
+	if parts := stats.Collectors[0].Partitions; len(parts) < 2 || parts[len(parts)-1].Bytes == 0 {
+		t.Errorf("expected postings to be written to disk, found %v", parts)
+	}
+
+	// The postings are unchanged by being written out
+	readers := index.GetPostingReaders("term0-1")
+	docs := 0
+	for _, r := range readers {
+		for r.Read() {
+			docs++
+		}
+	}
+	if docs != 10 {
+		t.Errorf("expected 10 postings, found %d", docs)
+	}
+
+	// Deleting from memory frees the memory used
+	before := index.collector.MemoryUsage()
+	index.Delete("/docs/200.txt")
+	if after := index.collector.MemoryUsage(); after >= before {
+		t.Errorf("memory use went from %d to %d after deleting", before, after)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	dir, _ := ioutil.TempDir("", "flash")
+	defer os.RemoveAll(dir)
+	files := map[string]int{
+		"a.txt":          10,
+		"big.txt":        1000,
+		"b.pdf":          10,
+		"sub/c.TXT":      10,
+		"sub/deep/d.txt": 10,
+		".hidden/e.txt":  10,
+	}
+	for name, size := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		ioutil.WriteFile(filepath.Join(dir, name), make([]byte, size), 0644)
+	}
+
+	viper.Set("roots", []Policy{{Dir: dir, MaxSize: 100, Extensions: []string{".txt"}, MaxDepth: 2, Hidden: true}})
+	defer viper.Set("roots", nil)
+
+	index := NewIndex(indexpath)
+	if p := index.Policy(filepath.Join(dir, "sub", "c.TXT")); p.Dir != dir || p.Extensions[0] != "txt" {
+		t.Fatal(p)
+	}
+
+	paths := make(chan string)
+	go func() {
+		index.addDir(dir, paths)
+		close(paths)
+	}()
+	var added []string
+	for path := range paths {
+		rel, _ := filepath.Rel(dir, path)
+		added = append(added, rel)
+	}
+	sort.Strings(added)
+	if expected := []string{".hidden/e.txt", "a.txt", "sub/c.TXT"}; !reflect.DeepEqual(added, expected) {
+		t.Errorf("expected %v, added %v", expected, added)
+	}
+
+	// Documents which the policy now excludes are pruned
+	for n, name := range []string{"a.txt", "big.txt", ".hidden/e.txt"} {
+		index.docs.Add(doclist.NewDocument(uint64(n+1), filepath.Join(dir, name), 1))
+	}
+	index.SetPolicy(Policy{Dir: dir, MaxSize: 100})
+	if removed := index.Prune(dir); removed != 2 {
+		t.Errorf("expected 2 documents to be pruned, removed %d", removed)
+	}
+	if _, ok := index.GetDocument(filepath.Join(dir, "a.txt")); !ok {
+		t.Error("pruned an allowed document")
+	}
+}
+
+func TestIgnoreFiles(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	dir, _ := ioutil.TempDir("", "flash")
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		".gitignore":            "build/\n*.log\n",
+		"a.txt":                 "",
+		"a.log":                 "",
+		"build/b.txt":           "",
+		"src/.flashignore":      "!keep.log\n",
+		"src/keep.log":          "",
+		"src/build/ignored.txt": "",
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+
+	viper.Set("dirs", []string{dir})
+	defer viper.Set("dirs", []string{})
+	index := NewIndex(indexpath)
+
+	paths := make(chan string)
+	go func() {
+		index.addDir(filepath.Join(dir, "src"), paths)
+		close(paths)
+	}()
+	var added []string
+	for path := range paths {
+		rel, _ := filepath.Rel(dir, path)
+		added = append(added, rel)
+	}
+	if len(added) != 1 || added[0] != "src/keep.log" {
+		t.Errorf("expected only src/keep.log to be added, added %v", added)
+	}
+
+	if index.add(filepath.Join(dir, "build", "b.txt"), &sync.RWMutex{}, func(bool) {}) {
+		t.Error("added a file in an ignored directory")
+	}
+}
+
+func TestBlacklistPurge(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	index := NewIndex(indexpath)
+	for n, path := range []string{"/docs/a.log", "/docs/b.txt", "/docs/logs/c.txt"} {
+		id := uint64(n + 1)
+		index.collector.Add("hello", &postingEntry{docID: id, frequency: 1})
+		index.docs.Add(doclist.NewDocument(id, path, 1))
+	}
+
+	if removed, err := index.Blacklist("glob:*.log"); err != nil || removed != 1 {
+		t.Errorf("expected 1 document to be removed, removed %d: %v", removed, err)
+	}
+	if removed, _ := index.Blacklist("/logs/"); removed != 1 {
+		t.Errorf("expected 1 document to be removed, removed %d", removed)
+	}
+	if _, err := index.Blacklist("["); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+
+	if _, ok := index.GetDocument("/docs/b.txt"); !ok || index.GetInfo().NumDocs != 1 {
+		t.Error("expected only /docs/b.txt to be left")
+	}
+	if r := index.GetPostingReaders("hello"); len(r) != 1 || !r[0].Read() {
+		t.Fatal("postings were removed")
+	} else if id, _ := r[0].Data(); id != 2 || r[0].Read() {
+		t.Errorf("expected only the postings of /docs/b.txt to be left")
+	}
+}
+
+func TestFollowLinks(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	dir, _ := ioutil.TempDir("", "flash")
+	defer os.RemoveAll(dir)
+	outside, _ := ioutil.TempDir("", "flash")
+	defer os.RemoveAll(outside)
+
+	os.MkdirAll(filepath.Join(dir, "real"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "real", "a.txt"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(outside, "b.txt"), nil, 0644)
+	os.Link(filepath.Join(dir, "real", "a.txt"), filepath.Join(dir, "real", "hard.txt"))
+	os.Symlink(filepath.Join(dir, "real"), filepath.Join(dir, "alias"))
+	os.Symlink(filepath.Join(dir, "real", "a.txt"), filepath.Join(dir, "a-link.txt"))
+	os.Symlink(outside, filepath.Join(dir, "outside"))
+	os.Symlink(dir, filepath.Join(dir, "real", "loop"))
+
+	index := NewIndex(indexpath)
+	walk := func() []string {
+		paths := make(chan string)
+		go func() {
+			index.addDir(dir, paths)
+			close(paths)
+		}()
+		var added []string
+		for path := range paths {
+			rel, _ := filepath.Rel(dir, path)
+			added = append(added, rel)
+		}
+		sort.Strings(added)
+		return added
+	}
+
+	if added := walk(); !reflect.DeepEqual(added, []string{"real/a.txt", "real/hard.txt"}) {
+		t.Errorf("links were followed: %v", added)
+	}
+
+	// Each file is found once, under its own path if it's in the root, while hard links
+	// are found under each of their paths
+	index.SetPolicy(Policy{Dir: dir, FollowLinks: true})
+	if added := walk(); !reflect.DeepEqual(added, []string{"outside/b.txt", "real/a.txt", "real/hard.txt"}) {
+		t.Errorf("expected the linked directory to be followed once, added %v", added)
+	}
+	if !samePath(filepath.Join(dir, "alias", "a.txt"), filepath.Join(dir, "real", "a.txt")) {
+		t.Error("expected paths through a link to be the same")
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	setup()
+	flashhome := viper.GetString("flashhome")
+	viper.Set("dirs", []string{"/home/user/personal"})
+	defer func() {
+		viper.Set("current_index", "")
+		viper.Set("indexes", nil)
+		viper.Set("dirs", []string{})
+	}()
+
+	if _, err := CreateProfile("Work", ""); err == nil {
+		t.Error("created an index with an uppercase name")
+	}
+	work, err := CreateProfile("work", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if work.Path != filepath.Join(flashhome, "indexes", "work") {
+		t.Errorf("expected the index to be stored under flashhome, got %v", work.Path)
+	}
+	if _, err := CreateProfile("work", ""); err == nil {
+		t.Error("created an index with the name of an existing one")
+	}
+
+	work.Dirs = []string{"/home/user/work"}
+	SaveProfile(work)
+	if p := CurrentProfile(); p.Name != DefaultProfile || !reflect.DeepEqual(p.Dirs, []string{"/home/user/personal"}) {
+		t.Errorf("saving another index changed the current one: %+v", p)
+	}
+
+	if err := UseProfile("work"); err != nil {
+		t.Fatal(err)
+	}
+	if p := CurrentProfile(); p.Name != "work" || p.Path != work.Path || !reflect.DeepEqual(p.Dirs, work.Dirs) {
+		t.Errorf("expected work to be the current index, got %+v", p)
+	}
+	if p, ok := GetProfile(DefaultProfile); !ok || !reflect.DeepEqual(p.Dirs, []string{"/home/user/personal"}) {
+		t.Errorf("expected the previous index to be kept, got %+v", p)
+	}
+	if names := len(Profiles()); names != 2 {
+		t.Errorf("expected 2 indexes, got %d", names)
+	}
+	if err := UseProfile("archive"); err == nil {
+		t.Error("used an index which doesn't exist")
+	}
+
+	// An index's roots decide its policies and which paths it contains
+	os.RemoveAll(work.Path)
+	defer os.RemoveAll(work.Path)
+	index, err := Open(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.Name() != "work" || index.GetPath() != work.Path {
+		t.Errorf("opened the wrong index: %v at %v", index.Name(), index.GetPath())
+	}
+	if !index.Contains("/home/user/work/a.txt") || index.Contains("/home/user/personal/a.txt") {
+		t.Error("expected the index to contain only the paths under its roots")
+	}
+	if p := index.Policy("/home/user/work/a.txt"); p.Dir != "/home/user/work" {
+		t.Errorf("expected the policy of the index's root, got %v", p.Dir)
+	}
+	if err := index.AddRoot("/home/user/work"); err == nil {
+		t.Error("added a root twice")
+	}
+}
+
+func TestTermDictionary(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	index := NewIndex(indexpath)
+	var terms []string
+	for n := 0; n < 10000; n++ {
+		term := fmt.Sprintf("term%05d", n)
+		terms = append(terms, term)
+		index.collector.Add(term, &postingEntry{docID: uint64(n + 1), frequency: 1})
+	}
+	index.collector.FlushMemory()
+
+	count := func(start, end string) int {
+		n := 0
+		index.collector.GetRange(start, end, func(string, partition.Entry) { n++ })
+		return n
+	}
+	lookup := func() {
+		for _, term := range terms {
+			if r := index.GetPostingReaders(term); len(r) != 1 {
+				t.Fatalf("%v not found", term)
+			}
+		}
+		for _, term := range []string{"", "a", "term", "term0000", "term10000", "zzz"} {
+			if r := index.GetPostingReaders(term); len(r) != 0 {
+				t.Errorf("found %q, which wasn't added", term)
+			}
+		}
+
+		n := 0
+		index.collector.GetPrefix("term012", func(string, partition.Entry) { n++ })
+		if n != 100 {
+			t.Errorf("expected 100 keys with the prefix, found %d", n)
+		}
+		if n := count("term00990", "term01010"); n != 20 {
+			t.Errorf("expected 20 keys in the range, found %d", n)
+		}
+		if n := count("term09990", ""); n != 10 {
+			t.Errorf("expected 10 keys after the start of the range, found %d", n)
+		}
+	}
+	lookup()
+
+	dicts, _ := filepath.Glob(indexpath + "/part_*.postings.dict")
+	if len(dicts) != 1 {
+		t.Fatal(dicts)
+	}
+	if info, err := os.Stat(dicts[0]); err != nil || info.Size() < 8+3*8 {
+		t.Fatal("expected the partition to be split into blocks")
+	}
+
+	// A missing dictionary is rebuilt from the blocks of the partition
+	index.ClearMemory()
+	os.Remove(dicts[0])
+	index = Load(indexpath)
+	lookup()
+	if problems := Check(indexpath); len(problems) != 0 {
+		t.Fatal(problems)
+	}
+}
+
+func TestDeleteAfterBudgetFlush(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	dir, _ := ioutil.TempDir("", "flash")
+	defer os.RemoveAll(dir)
+
+	index := NewIndex(indexpath)
+	index.budget.SetLimit(4 << 10)
+	var paths []string
+	for n := 0; n < 100; n++ {
+		path := fmt.Sprintf("%v/%03d.txt", dir, n)
+		ioutil.WriteFile(path, []byte("hello"), 0644)
+		index.insert(extractedFile(path))
+		paths = append(paths, path)
+	}
+
+	// Deleting documents must not remove the partitions holding the others
+	for _, path := range paths[60:] {
+		index.Delete(path)
+	}
+	check := func() {
+		for _, path := range paths[:60] {
+			if _, ok := index.docs.FetchPath(path); !ok {
+				t.Fatalf("%v was lost", path)
+			}
+		}
+		docs := 0
+		for _, r := range index.GetPostingReaders("hello") {
+			for r.Read() {
+				docs++
+			}
+		}
+		if docs != 60 || index.GetInfo().NumDocs != 60 {
+			t.Errorf("expected 60 documents, found %d postings and %d documents", docs, index.GetInfo().NumDocs)
+		}
+	}
+	check()
+
+	index.ClearMemory()
+	index = Load(indexpath)
+	check()
+}
+
+func TestNearDuplicates(t *testing.T) {
+	// Fingerprints with one bit set in each band used at a threshold of 0.9
+	spread := func(bands int) uint64 {
+		var f uint64
+		for b := 0; b < bands; b++ {
+			f |= 1 << uint(b*9)
+		}
+		return f
+	}
+
+	tests := []struct {
+		name         string
+		fingerprints []uint64
+		threshold    float64
+		members      [][]int
+		similarity   []float64
+	}{
+		{"one bit apart", []uint64{0, 1, ^uint64(0)}, 0.9, [][]int{{0, 1}}, []float64{63.0 / 64}},
+		{"a band in common", []uint64{0, spread(6)}, 0.9, [][]int{{0, 1}}, []float64{58.0 / 64}},
+		{"every band differs", []uint64{0, spread(7)}, 0.9, nil, nil},
+		{"merged through a chain", []uint64{0, 0x0f, 0xff}, 0.9, [][]int{{0, 1, 2}}, []float64{60.0 / 64}},
+		{"separate groups", []uint64{0, 0x0f, ^uint64(0), ^uint64(0xf0)}, 0.9, [][]int{{0, 1}, {2, 3}}, []float64{60.0 / 64, 60.0 / 64}},
+		{"above the threshold", []uint64{0, 0x0f}, 0.93, [][]int{{0, 1}}, []float64{60.0 / 64}},
+		{"below the threshold", []uint64{0, 0x0f}, 0.95, nil, nil},
+		{"exact threshold", []uint64{0, 0x0f}, 1, nil, nil},
+		{"low threshold is raised", []uint64{0, 0xffff, 0x1ffff}, 0, [][]int{{0, 1, 2}}, []float64{0.75}},
+		{"unrelated at low threshold", []uint64{0, ^uint64(0)}, 0, nil, nil},
+	}
+
+	for _, test := range tests {
+		docs := make([]*doclist.Document, len(test.fingerprints))
+		for d, f := range test.fingerprints {
+			docs[d] = doclist.NewDocument(uint64(d), fmt.Sprint(d), 1)
+			docs[d].SetContent([]byte{byte(d)}, f)
+		}
+
+		sets := nearDuplicates(docs, test.threshold)
+		var members [][]int
+		var similarity []float64
+		for _, set := range sets {
+			sort.Ints(set.members)
+			members = append(members, set.members)
+			similarity = append(similarity, set.similarity)
+		}
+		sort.Slice(members, func(a, b int) bool { return members[a][0] < members[b][0] })
+		if !reflect.DeepEqual(members, test.members) || !reflect.DeepEqual(similarity, test.similarity) {
+			t.Errorf("%v: expected %v with similarity %v, got %v with %v", test.name, test.members, test.similarity, members, similarity)
+		}
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	setup()
+	indexpath := viper.GetString("indexpath")
+	os.RemoveAll(indexpath)
+	defer os.RemoveAll(indexpath)
+
+	index := NewIndex(indexpath)
+	for id, doc := range []struct {
+		path        string
+		length      uint32
+		hash        string
+		fingerprint uint64
+	}{
+		{"/a.txt", 1, "same", 0},
+		{"/b.txt", 1, "same", 0},
+		{"/c.txt", 1, "similar", 1},
+		{"/d.txt", 1, "different", ^uint64(0)},
+		{"/empty.txt", 0, "empty", 0},
+		{"/unhashed.txt", 1, "", 0},
+	} {
+		d := doclist.NewDocument(uint64(id+1), doc.path, doc.length)
+		d.SetContent([]byte(doc.hash), doc.fingerprint)
+		index.docs.Add(d)
+	}
+
+	// Exact duplicates are grouped by their hash, and compared with other documents as one
+	expected := []DuplicateGroup{
+		{Paths: []string{"/a.txt", "/b.txt"}, Similarity: 1, Exact: true},
+		{Paths: []string{"/a.txt", "/b.txt", "/c.txt"}, Similarity: 63.0 / 64},
+	}
+	if groups := index.Duplicates(0.9); !reflect.DeepEqual(groups, expected) {
+		t.Errorf("expected %v, got %v", expected, groups)
+	}
+	if groups := index.Duplicates(1); len(groups) != 1 || !groups[0].Exact {
+		t.Errorf("expected only the exact duplicates, got %v", groups)
+	}
+}
@@ -6,9 +6,7 @@ import (
 	"flash/pkg/index/partition"
 	"flash/pkg/index/postinglist"
 	"flash/tools/readers"
-	"fmt"
 	"io"
-	"os"
 	"strconv"
 )
 
@@ -16,10 +14,13 @@ import (
 type Partition struct {
 	data        map[string]*postinglist.List
 	invalidDocs map[uint64]bool
+	docs        map[uint64]bool
+	bytes       int64
 }
 
 type postingEntry struct {
-	docID uint64
+	docID     uint64
+	frequency uint32
 }
 
 // NewPartition creates a new indexPartition
@@ -27,6 +28,7 @@ func NewPartition() partition.Implementation {
 	p := Partition{
 		data:        make(map[string]*postinglist.List),
 		invalidDocs: make(map[uint64]bool),
+		docs:        make(map[uint64]bool),
 	}
 
 	return &p
@@ -34,16 +36,27 @@ func NewPartition() partition.Implementation {
 
 // Add adds a term and an entry to the index
 func (p *Partition) Add(term string, entry partition.Entry) {
+	before := p.usage(term)
 	switch entry.(type) {
 	case *postingEntry:
 		e := entry.(*postingEntry)
 		if _, ok := p.data[term]; !ok {
 			p.data[term] = postinglist.NewList()
 		}
-		p.data[term].Add(e.docID, 1)
+		p.data[term].Add(e.docID, e.frequency)
+		delete(p.invalidDocs, e.docID)
 	case *postinglist.List:
-		p.data[term] = entry.(*postinglist.List)
+		l := entry.(*postinglist.List)
+		for _, id := range l.GetDocs() {
+			delete(p.invalidDocs, id)
+		}
+		if pl, ok := p.data[term]; ok {
+			pl.Merge(l)
+		} else {
+			p.data[term] = l
+		}
 	}
+	p.bytes += p.usage(term) - before
 }
 
 // Delete removes a document from the index
@@ -61,11 +74,27 @@ func (p *Partition) Delete(doc string) {
 
 	// If partition is in memory, remove the postings for the doc
 	for term, pl := range p.data {
+		before := p.usage(term)
 		pl.Delete(id)
 		if pl.Empty() {
 			delete(p.data, term)
 		}
+		p.bytes += p.usage(term) - before
+	}
+}
+
+// Holds returns true if the partition on disk has postings for the document
+func (p *Partition) Holds(doc string) bool {
+	id, err := strconv.ParseUint(doc, 10, 64)
+	return err == nil && p.docs[id]
+}
+
+// usage returns the approximate number of bytes used by the term and its posting list
+func (p *Partition) usage(term string) int64 {
+	if l, ok := p.data[term]; ok {
+		return int64(len(term)) + partition.MapEntrySize + l.MemoryUsage()
 	}
+	return 0
 }
 
 // Get returns an entry for a given term from the index
@@ -95,12 +124,23 @@ func (p *Partition) Keys() []string {
 	return keys
 }
 
-// Clear removes the data from the partition
+// Clear removes the data from the partition, keeping the ids of its documents
 func (p *Partition) Clear() {
+	for _, l := range p.data {
+		for _, id := range l.GetDocs() {
+			p.docs[id] = true
+		}
+	}
 	p.data = nil
+	p.bytes = 0
+}
+
+// MemoryUsage returns the approximate number of bytes used by the posting lists held in memory
+func (p *Partition) MemoryUsage() int64 {
+	return p.bytes
 }
 
-// Merge merges the posting lists given by the set of readers
+// Merge merges the posting lists given by the set of readers, returning nil if every posting was invalidated
 func (p *Partition) Merge(readers []*partition.Reader, impls []partition.Implementation) partition.Entry {
 	plist := postinglist.NewList()
 	for i := 0; i < len(readers); i++ {
@@ -111,77 +151,54 @@ func (p *Partition) Merge(readers []*partition.Reader, impls []partition.Impleme
 			for r.Read() {
 				id, freq := r.Data()
 				plist.Add(id, freq)
+				p.docs[id] = true
 			}
 		}
 	}
+
+	if plist.Empty() {
+		return nil
+	}
 	return plist
 }
 
+// Tombstones returns the number of documents which have been invalidated in the partition
+func (p *Partition) Tombstones() int {
+	return len(p.invalidDocs)
+}
+
 // LoadInfo loads in information about the partition into memory
 func (p *Partition) LoadInfo(r io.Reader) {
-	num := readers.ReadUint32(r)
-	for i := uint32(0); i < num; i++ {
-		key := readers.ReadUint64(r)
-		p.invalidDocs[key] = true
-	}
+	readIDs(r, p.invalidDocs)
+	readIDs(r, p.docs)
 }
 
-// GetInfo returns a buffer containing info that must be saved about the partition
+// GetInfo returns a buffer containing info that must be saved about the partition,
+// the invalidated documents followed by every document with postings in it
 func (p *Partition) GetInfo() *bytes.Buffer {
 	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, uint32(len(p.invalidDocs)))
-	for key := range p.invalidDocs {
-		binary.Write(buf, binary.LittleEndian, key)
-	}
+	writeIDs(buf, p.invalidDocs)
+	writeIDs(buf, p.docs)
 	return buf
 }
 
-// GC performs garbage collection on the partition
-func (p *Partition) GC(reader *partition.Reader, out string) (size int) {
-	temp, err := os.Create(out)
-	if err != nil {
-		fmt.Println(err)
-		return
+func readIDs(r io.Reader, ids map[uint64]bool) {
+	num := readers.ReadUint32(r)
+	for i := uint32(0); i < num; i++ {
+		ids[readers.ReadUint64(r)] = true
 	}
-	defer temp.Close()
-
-	running := true
-	for running {
-		reader.FetchDataLength()
-		pr := postinglist.NewReader(reader.FetchData(), p.invalidDocs)
-
-		postingBuf := new(bytes.Buffer)
-		numDocs := 0
-		for pr.Read() {
-			id, freq := pr.Data()
-			binary.Write(postingBuf, binary.LittleEndian, id)
-			binary.Write(postingBuf, binary.LittleEndian, freq)
-
-			numDocs++
-			size += int(freq)
-		}
-
-		if numDocs > 0 {
-			buf := new(bytes.Buffer)
-			key := reader.CurrentKey()
-
-			binary.Write(buf, binary.LittleEndian, uint32(len(key)))
-			binary.Write(buf, binary.LittleEndian, []byte(key))
-			binary.Write(buf, binary.LittleEndian, uint32(4+postingBuf.Len()))
-			binary.Write(buf, binary.LittleEndian, uint32(numDocs))
+}
 
-			buf.WriteTo(temp)
-			postingBuf.WriteTo(temp)
-		}
-		running = reader.NextKey()
+func writeIDs(w io.Writer, ids map[uint64]bool) {
+	binary.Write(w, binary.LittleEndian, uint32(len(ids)))
+	for id := range ids {
+		binary.Write(w, binary.LittleEndian, id)
 	}
-
-	p.invalidDocs = make(map[uint64]bool)
-	return size
 }
 
+// Bytes encodes the entry as a posting list containing a single posting
 func (pe *postingEntry) Bytes() *bytes.Buffer {
-	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, pe.docID)
-	return buf
+	l := postinglist.NewList()
+	l.Add(pe.docID, pe.frequency)
+	return l.Bytes()
 }
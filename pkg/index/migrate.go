@@ -0,0 +1,101 @@
+package index
+
+import (
+	"bytes"
+	"flash/pkg/index/doclist"
+	"flash/pkg/index/partition"
+	"flash/pkg/index/postinglist"
+	"fmt"
+)
+
+// migrations upgrade an index from the version they are keyed by to the next version
+var migrations = map[uint32]func(indexpath string) error{
+	0: migrateLegacy,
+	1: migrateManifest,
+	2: migrateBlocks,
+	3: migrateDocuments,
+	4: migrateDocSets,
+}
+
+// Migrate upgrades the index at the given path to the current format version,
+// returning the version it was upgraded from
+func Migrate(indexpath string) (uint32, error) {
+	from, err := partition.CollectorVersion(indexpath, "postings")
+	if err != nil {
+		return 0, err
+	}
+
+	if from > partition.Version {
+		return from, fmt.Errorf("index uses format version %d, which is newer than this version of flash supports", from)
+	}
+
+	for v := from; v < partition.Version; v++ {
+		if err := migrations[v](indexpath); err != nil {
+			return from, fmt.Errorf("could not migrate from version %d: %w", v, err)
+		}
+	}
+	return from, nil
+}
+
+// migrateLegacy adds headers to every file, and compresses the posting lists
+func migrateLegacy(indexpath string) error {
+	err := partition.MigrateLegacy(indexpath, "postings", func(data []byte) []byte {
+		return postinglist.DecodeLegacy(bytes.NewBuffer(data)).Bytes().Bytes()
+	})
+	if err != nil {
+		return err
+	}
+
+	return doclist.MigrateLegacy(indexpath)
+}
+
+// migrateManifest replaces the info files of each collector with manifests
+func migrateManifest(indexpath string) error {
+	if err := partition.MigrateManifest(indexpath, "postings"); err != nil {
+		return err
+	}
+
+	return doclist.MigrateManifest(indexpath)
+}
+
+// migrateBlocks front codes the keys of the partition files of each collector in blocks
+func migrateBlocks(indexpath string) error {
+	if err := partition.MigrateBlocks(indexpath, "postings"); err != nil {
+		return err
+	}
+
+	return doclist.MigrateBlocks(indexpath)
+}
+
+// migrateDocuments rewrites the documents of the doclist with every field
+func migrateDocuments(indexpath string) error {
+	if err := partition.MigrateValues(indexpath, "postings", nil); err != nil {
+		return err
+	}
+
+	return doclist.MigrateDocuments(indexpath)
+}
+
+// migrateDocSets records the documents which have postings in each partition, so that
+// deletes only leave tombstones in the partitions which hold the document
+func migrateDocSets(indexpath string) error {
+	err := partition.MigrateInfo(indexpath, "postings", func(values func(fn func(data []byte))) []byte {
+		docs := make(map[uint64]bool)
+		values(func(data []byte) {
+			r := postinglist.NewReader(bytes.NewBuffer(data), nil)
+			for r.Read() {
+				id, _ := r.Data()
+				docs[id] = true
+			}
+		})
+
+		buf := new(bytes.Buffer)
+		writeIDs(buf, docs)
+		return buf.Bytes()
+	})
+	if err != nil {
+		return err
+	}
+
+	return doclist.MigrateDocSets(indexpath)
+}
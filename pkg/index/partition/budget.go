@@ -0,0 +1,79 @@
+package partition
+
+// DefaultMemoryBudget is the budget of collectors which haven't been given one
+const DefaultMemoryBudget = 256 << 20
+
+// MapEntrySize approximates the memory used by an entry in a map of an implementation,
+// excluding the key's bytes. Implementations add it to the size of each value they hold
+const MapEntrySize = 48
+
+// Budget limits the memory used by the memory partitions of a group of collectors. Once
+// they use more than the limit between them, the largest is written to disk
+type Budget struct {
+	limit      int64
+	collectors []*Collector
+}
+
+// NewBudget creates a budget of the given number of bytes, or the default budget if it isn't positive
+func NewBudget(limit int64) *Budget {
+	b := &Budget{}
+	b.SetLimit(limit)
+	return b
+}
+
+// SetLimit changes the number of bytes the collectors may use, it applies from the next add
+func (b *Budget) SetLimit(limit int64) {
+	if limit <= 0 {
+		limit = DefaultMemoryBudget
+	}
+	b.limit = limit
+}
+
+// Limit returns the number of bytes the collectors may use
+func (b *Budget) Limit() int64 {
+	return b.limit
+}
+
+// Used returns the number of bytes used by the memory partitions of the collectors
+func (b *Budget) Used() int64 {
+	var used int64
+	for _, c := range b.collectors {
+		used += c.memory.impl.MemoryUsage()
+	}
+	return used
+}
+
+// enforce writes the largest memory partitions to disk until the collectors are within the budget
+func (b *Budget) enforce() {
+	for b.Used() > b.limit {
+		var largest *Collector
+		for _, c := range b.collectors {
+			if largest == nil || c.memory.impl.MemoryUsage() > largest.memory.impl.MemoryUsage() {
+				largest = c
+			}
+		}
+		if largest.memory.impl.MemoryUsage() == 0 {
+			return
+		}
+		largest.FlushMemory()
+	}
+}
+
+// SetBudget moves the collector to the given budget, which it shares with any other collectors using it
+func (c *Collector) SetBudget(b *Budget) {
+	if old := c.budget; old != nil {
+		for i := range old.collectors {
+			if old.collectors[i] == c {
+				old.collectors = append(old.collectors[:i], old.collectors[i+1:]...)
+				break
+			}
+		}
+	}
+	b.collectors = append(b.collectors, c)
+	c.budget = b
+}
+
+// MemoryUsage returns the number of bytes used by the collector's memory partition
+func (c *Collector) MemoryUsage() int64 {
+	return c.memory.impl.MemoryUsage()
+}
@@ -0,0 +1,262 @@
+package partition
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// ProblemKind describes which file of a collector is broken
+type ProblemKind int
+
+// The kinds of problems found by Check
+const (
+	BrokenManifest ProblemKind = iota
+	BrokenJournal
+	BrokenPartition
+	BrokenInfo
+	BrokenDictionary
+)
+
+// Problem is a broken file found in a collector
+type Problem struct {
+	Kind        ProblemKind
+	Extension   string
+	Generation  int
+	Path        string
+	Description string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%v: %v", filepath.Base(p.Path), p.Description)
+}
+
+// LosesData returns true if repairing the problem removes entries from the
+// collector, meaning that the affected files must be reindexed
+func (p Problem) LosesData() bool {
+	return p.Kind != BrokenDictionary
+}
+
+// Check validates the files of the collector with the given extension, without loading
+// it. If validate isn't nil, it's called with every value to check that it can be decoded
+func Check(dir, extension string, validate func(data []byte) error) []Problem {
+	c := &Collector{dir: dir, extension: extension}
+	var problems []Problem
+	report := func(kind ProblemKind, gen int, path string, err error) {
+		problems = append(problems, Problem{kind, extension, gen, path, err.Error()})
+	}
+
+	gens, err := c.readManifest()
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		report(BrokenManifest, 0, c.getManifestPath(), err)
+		gens = c.partitionFiles()
+	}
+
+	if err := checkJournal(c.getWALPath()); err != nil && !os.IsNotExist(err) {
+		report(BrokenJournal, 0, c.getWALPath(), err)
+	}
+
+	for _, gen := range gens {
+		p := newPartition(dir, extension, gen, nil)
+		if err := checkPartition(p.getPath(), validate); err != nil {
+			report(BrokenPartition, gen, p.getPath(), err)
+			continue
+		}
+		if err := checkInfo(p.getInfoPath()); err != nil {
+			report(BrokenInfo, gen, p.getInfoPath(), err)
+		}
+		if err := checkDictionary(p.getPath()); err != nil && !os.IsNotExist(err) {
+			report(BrokenDictionary, gen, p.getPath()+".dict", err)
+		}
+	}
+	return problems
+}
+
+// Repair fixes the problems found in a collector. Dictionaries are removed so that
+// they're rebuilt when the collector is loaded, and broken partitions are dropped.
+// If the manifest is broken, it's rewritten to list the remaining partitions
+func Repair(dir, extension string, problems []Problem) error {
+	c := &Collector{dir: dir, extension: extension}
+	gens, err := c.readManifest()
+	if err != nil {
+		gens = c.partitionFiles()
+	}
+
+	dropped := make(map[int]bool)
+	rewrite := err != nil
+	for _, p := range problems {
+		switch p.Kind {
+		case BrokenDictionary:
+			os.Remove(p.Path)
+		case BrokenJournal:
+			os.Remove(p.Path)
+		case BrokenPartition, BrokenInfo:
+			dropped[p.Generation] = true
+			rewrite = true
+		}
+	}
+	if !rewrite {
+		return syncDir(dir)
+	}
+
+	for _, gen := range gens {
+		if !dropped[gen] {
+			c.disk = append(c.disk, newPartition(dir, extension, gen, nil))
+		}
+	}
+	if err := c.writeManifest(); err != nil {
+		return err
+	}
+
+	for gen := range dropped {
+		newPartition(dir, extension, gen, nil).deleteFiles()
+	}
+	return nil
+}
+
+// readManifest returns the generations listed in the manifest, without loading the partitions
+func (c *Collector) readManifest() ([]int, error) {
+	data, err := ioutil.ReadFile(c.getManifestPath())
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := readVersion(bytes.NewReader(data))
+	if err != nil || version != Version {
+		return nil, fmt.Errorf("invalid header")
+	}
+	if len(data) < headerSize+4 || (len(data)-headerSize)%4 != 0 {
+		return nil, fmt.Errorf("manifest is truncated")
+	}
+
+	c.seq = binary.LittleEndian.Uint32(data[headerSize : headerSize+4])
+	var gens []int
+	for i := headerSize + 4; i+4 <= len(data); i += 4 {
+		gens = append(gens, int(binary.LittleEndian.Uint32(data[i:i+4])))
+	}
+	return gens, nil
+}
+
+// partitionFiles returns the generations of every partition file in the directory
+func (c *Collector) partitionFiles() []int {
+	pattern := regexp.MustCompile(`^part_(\d+)\.` + regexp.QuoteMeta(c.extension) + `$`)
+	files, _ := ioutil.ReadDir(c.dir)
+
+	var gens []int
+	for _, f := range files {
+		if m := pattern.FindStringSubmatch(f.Name()); m != nil {
+			gen, _ := strconv.Atoi(m[1])
+			gens = append(gens, gen)
+		}
+	}
+	sort.Ints(gens)
+	return gens
+}
+
+func checkJournal(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := ReadHeader(f, path); err != nil && !errors.Is(err, io.EOF) {
+		return errors.New("invalid header")
+	}
+	return nil
+}
+
+// checkPartition reads every entry in the partition, checking that they're complete and in order
+func checkPartition(path string, validate func(data []byte) error) error {
+	m, err := openMapping(path)
+	if err != nil {
+		return err
+	}
+	defer m.release()
+
+	header, ok := m.slice(0, headerSize)
+	if !ok || ReadHeader(bytes.NewReader(header), path) != nil {
+		return errors.New("invalid header")
+	}
+
+	first, prev := true, ""
+	for offset := int64(headerSize); offset < int64(len(m.data)); {
+		key, next, ok := m.key(offset, prev)
+		if !ok {
+			return fmt.Errorf("key after %q is truncated", prev)
+		}
+		data, next, ok := m.field(next)
+		if !ok {
+			return fmt.Errorf("value of %q is truncated", key)
+		}
+
+		if !first && key <= prev {
+			return fmt.Errorf("key %q is out of order", key)
+		}
+		if validate != nil {
+			if err := validate(data); err != nil {
+				return fmt.Errorf("value of %q is invalid: %v", key, err)
+			}
+		}
+		first, prev, offset = false, key, next
+	}
+	return nil
+}
+
+func checkInfo(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if version, err := readVersion(bytes.NewReader(data)); err != nil || version != Version {
+		return errors.New("invalid header")
+	}
+	if len(data) < headerSize+8 {
+		return errors.New("info is truncated")
+	}
+	return nil
+}
+
+// checkDictionary checks that every offset in the dictionary points to the start of a
+// block in the partition, where a full key is stored, and that the blocks are in order
+func checkDictionary(target string) error {
+	data, err := ioutil.ReadFile(target + ".dict")
+	if err != nil {
+		return err
+	}
+	if version, err := readVersion(bytes.NewReader(data)); err != nil || version != Version {
+		return errors.New("invalid header")
+	}
+	if (len(data)-headerSize)%8 != 0 {
+		return errors.New("dictionary is truncated")
+	}
+
+	m, err := openMapping(target)
+	if err != nil {
+		return err
+	}
+	defer m.release()
+
+	var prev int64
+	for i := headerSize; i < len(data); i += 8 {
+		offset := int64(binary.LittleEndian.Uint64(data[i:]))
+		if offset <= prev {
+			return fmt.Errorf("offset %d is out of order", offset)
+		}
+		if shared, _, ok := m.uvarint(offset); !ok || shared != 0 {
+			return fmt.Errorf("offset %d isn't the start of a block", offset)
+		}
+		prev = offset
+	}
+	return nil
+}
@@ -1,18 +1,13 @@
 package partition
 
 import (
-	"bufio"
 	"bytes"
-	"encoding/binary"
 	"fmt"
-	"io"
+	"log"
 	"os"
-	"sort"
 	"strings"
 )
 
-const partitionLimit = 1 << 24
-
 // Collector is used to abstract partitioning, automatically writing and merging partitions where needed
 type Collector struct {
 	dir               string
@@ -20,6 +15,13 @@ type Collector struct {
 	memory            *partition
 	disk              []*partition
 	newImplementation func() Implementation
+	wal               *wal
+	seq               uint32
+	recovered         bool
+	policy            MergePolicy
+	merging           *backgroundMerge
+	generation        int
+	budget            *Budget
 }
 
 // NewCollector creates a new collector
@@ -28,42 +30,87 @@ func NewCollector(dir, extension string, newImplementation func() Implementation
 		dir:               dir,
 		extension:         extension,
 		newImplementation: newImplementation,
+		policy:            DefaultMergePolicy,
 	}
 	c.addPartition()
+	c.SetBudget(NewBudget(DefaultMemoryBudget))
 	return &c
 }
 
-// Load will load the partitions into memory
+// Load will load the partitions listed in the manifest, remove any files left
+// behind by an interrupted write, and replay the journaled operations which
+// were applied to the memory partition
 func (c *Collector) Load() error {
-	return c.loadInfo()
+	err := c.loadManifest()
+	if _, walErr := os.Stat(c.getWALPath()); err != nil && !(os.IsNotExist(err) && walErr == nil) {
+		return err
+	}
+	c.removeOrphans()
+
+	// Values are decoded without the memory partition's tombstones, as
+	// they only apply to values added before the tombstone was created
+	decoder := c.newImplementation()
+	num, err := replayWAL(c.getWALPath(), c.seq, func(op byte, key string, data *bytes.Buffer) {
+		switch op {
+		case walRestore, walAdd:
+			if val, ok := decoder.Decode(key, data); ok {
+				c.memory.add(key, val)
+			}
+		case walDelete:
+			c.delete(key)
+		}
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if num > 0 {
+		fmt.Printf("Recovered %d operations from %v\n", num, c.getWALPath())
+		c.recovered = true
+	}
+	return nil
+}
+
+// Recovered returns true if operations were replayed from the journal when the collector was loaded,
+// meaning that the collector was not cleared before it was last closed
+func (c *Collector) Recovered() bool {
+	return c.recovered
 }
 
 // Add insets a new key value pair into the index
 func (c *Collector) Add(key string, val Entry) {
-	if c.memory.full() {
-		c.mergeParitions()
-	}
+	c.finishMerge(false)
+	c.journal().add(key, val)
 	c.memory.add(key, val)
+	c.budget.enforce()
 }
 
 // Delete removes the given key from all partitions
 func (c *Collector) Delete(key string) {
-	c.memory.delete(key)
-	d := 0
-	for i := range c.disk {
-		p := c.disk[i-d]
-		p.delete(key)
+	c.finishMerge(false)
+	c.journal().delete(key)
+	c.delete(key)
+	if c.merging != nil {
+		c.merging.deleted = append(c.merging.deleted, key)
+	}
+}
 
-		if p.size == 0 {
-			p.deleteFiles()
-			c.disk[i-d] = c.disk[len(c.disk)-1]
-			c.disk[len(c.disk)-1] = nil
-			c.disk = c.disk[:len(c.disk)-1]
-			d++
+// Flush writes any buffered journal entries, it should be called once a set of related operations is complete
+func (c *Collector) Flush() {
+	if c.wal != nil {
+		if err := c.wal.flush(); err != nil {
+			fmt.Println(err)
 		}
 	}
 }
 
+func (c *Collector) delete(key string) {
+	c.memory.delete(key)
+	for _, p := range c.disk {
+		p.delete(key)
+	}
+}
+
 // GetMatching returns a list of values which match the key
 func (c *Collector) GetMatching(key string) []Entry {
 	var matches []Entry
@@ -76,15 +123,15 @@ func (c *Collector) GetMatching(key string) []Entry {
 	}
 
 	for _, p := range c.disk {
-		r := NewReader(p.getPath())
+		r := p.newReader()
 		for !r.done {
 			r.FetchDataLength()
-			data := r.FetchData()
-
 			if strings.Contains(r.currentKey, key) {
-				if e, ok := p.impl.Decode(r.currentKey, data); ok {
+				if e, ok := p.impl.Decode(r.currentKey, r.FetchData()); ok {
 					matches = append(matches, e)
 				}
+			} else {
+				r.SkipData()
 			}
 
 			r.NextKey()
@@ -95,6 +142,26 @@ func (c *Collector) GetMatching(key string) []Entry {
 	return matches
 }
 
+// GetPrefix calls fn with every valid value whose key starts with the prefix, without
+// reading the keys of the partitions on disk which come before it
+func (c *Collector) GetPrefix(prefix string, fn func(key string, val Entry)) {
+	c.GetRange(prefix, prefixEnd(prefix), fn)
+}
+
+// GetRange calls fn with every valid value whose key is at least start and before end,
+// or every key from start if end is empty. Only the blocks of the partitions on disk
+// which hold keys in the range are read
+func (c *Collector) GetRange(start, end string, fn func(key string, val Entry)) {
+	for _, p := range append(c.disk, c.memory) {
+		p.scanRange(start, end, fn)
+	}
+}
+
+// GetAll returns every valid value in the collector
+func (c *Collector) GetAll() []Entry {
+	return c.GetMatching("")
+}
+
 // GetBuffers returns all of the buffers which use the given key and their respective implementations
 func (c *Collector) GetBuffers(key string) ([]*bytes.Buffer, []Implementation) {
 	var buffers []*bytes.Buffer
@@ -123,102 +190,77 @@ func (c *Collector) addPartition() {
 	if c.memory != nil {
 		c.disk = append(c.disk, c.memory)
 	}
-	c.memory = newPartition(c.dir, c.extension, 0, partitionLimit, c.newImplementation())
+	c.memory = newPartition(c.dir, c.extension, 0, c.newImplementation())
 }
 
-func (c *Collector) mergeParitions() {
-	// Dump current partition into temp file
+// FlushMemory writes the memory partition to disk as a new partition, and starts
+// merging the partitions on disk in the background if the merge policy requires it
+func (c *Collector) FlushMemory() {
 	mem := c.memory
 	mem.dump()
 
-	// Sort partitions in order of generation
-	sort.Slice(c.disk, func(p1, p2 int) bool {
-		return c.disk[p1].generation < c.disk[p2].generation
-	})
-
-	// Anticipate collisions
-	g := 1
-	var parts []*partition
-	for p := 0; p < len(c.disk); p++ {
-		if c.disk[p].generation == g {
-			parts = append(parts, c.disk[p])
-			g++
-		} else {
-			break
-		}
-	}
-
-	if len(parts) == 0 {
-		oldPath := mem.getPath()
-		// Set current partition as final
-		mem.updateGeneration(1)
-		os.Rename(oldPath, mem.getPath())
-		mem.loadDict()
-	} else {
-		// Remove old partitions from the index
-		c.disk = c.disk[len(parts):]
-		c.memory = nil
-		// Merge partitions
-		p := newPartition(c.dir, c.extension, g, partitionLimit, c.newImplementation())
-		merge(append(parts, mem), p)
-		c.disk = append(c.disk, p)
-		p.loadDict()
-	}
+	oldPath := mem.getPath()
+	mem.updateGeneration(c.nextGeneration())
+	os.Rename(oldPath, mem.getPath())
+	syncDir(c.dir)
+	forgetMapping(mem.getPath())
+	mem.loadDict()
 
 	c.addPartition()
+	c.checkpoint()
+	c.startMerge()
 }
 
-func (c *Collector) dumpInfo() {
-	path := fmt.Sprintf("%v/%v.info", c.dir, c.extension)
-	f, err := os.Create(path)
-	if err != nil {
-		fmt.Println(err)
-		return
+// checkpoint records the partitions on disk in the manifest, after which the
+// operations in the journal are no longer needed. A new journal sequence number
+// is recorded in the manifest first, so that if the journal can't be reset the
+// old operations are ignored rather than replayed twice
+func (c *Collector) checkpoint() bool {
+	for _, p := range c.disk {
+		p.dumpInfo()
 	}
 
-	buf := new(bytes.Buffer)
-	for p := range c.disk {
-		binary.Write(buf, binary.LittleEndian, uint32(c.disk[p].generation))
+	c.seq++
+	if err := c.writeManifest(); err != nil {
+		fmt.Println(err)
+		c.seq--
+		return false
 	}
-	binary.Write(buf, binary.LittleEndian, uint32(c.memory.generation))
-	buf.WriteTo(f)
-}
 
-func (c *Collector) loadInfo() error {
-	path := fmt.Sprintf("%v/%v.info", c.dir, c.extension)
-	f, err := os.Open(path)
-	if err != nil {
-		return err
+	if err := c.journal().reset(c.seq); err != nil {
+		fmt.Println(err)
 	}
-	defer f.Close()
-
-	reader := bufio.NewReader(f)
-	buf := make([]byte, 4)
-
-	for {
-		n, err := io.ReadFull(reader, buf)
-		if n == 0 || err != nil {
-			break
-		}
-
-		gen := int(binary.LittleEndian.Uint32(buf))
-		part := loadPartition(c.dir, c.extension, gen, partitionLimit, c.newImplementation())
+	return true
+}
 
-		// Load in-memory index
-		if gen == 0 {
-			c.memory = part
-		} else {
-			c.disk = append(c.disk, part)
+func (c *Collector) journal() *wal {
+	if c.wal == nil {
+		w, err := openWAL(c.getWALPath(), c.seq)
+		if err != nil {
+			log.Fatalf("Could not open journal: %v\n", err)
 		}
+		c.wal = w
 	}
-	return nil
+	return c.wal
+}
+
+func (c *Collector) getWALPath() string {
+	return fmt.Sprintf("%v/%v.wal", c.dir, c.extension)
 }
 
-// ClearMemory writes any remaining info to disk
+// ClearMemory writes any remaining info to disk. Rather than being written as a
+// partition, the memory partition is saved by compacting the journal
 func (c *Collector) ClearMemory() {
-	c.dumpInfo()
-	c.memory.dump()
-	for _, p := range append(c.disk, c.memory) {
+	c.finishMerge(true)
+	for _, p := range c.disk {
 		p.dumpInfo()
 	}
+	if err := c.writeManifest(); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if err := c.journal().compact(c.seq, c.memory.impl); err != nil {
+		fmt.Println(err)
+	}
 }
@@ -0,0 +1,44 @@
+package partition
+
+// Compact starts rewriting the partition with the most tombstones in the background,
+// leaving out the entries which they invalidate. Partitions with fewer than minTombstones
+// are left alone. The returned channel is closed once the compaction is complete, after
+// which FinishMerge swaps it in. If a merge is already running, its channel is returned
+// with compacting false, and Compact should be called again once it's swapped in. Nil
+// is returned if there's nothing to do
+func (c *Collector) Compact(minTombstones int) (done <-chan struct{}, compacting bool) {
+	if c.merging != nil {
+		return c.merging.done, false
+	}
+	p := c.mostTombstones(minTombstones)
+	if p == nil {
+		return nil, false
+	}
+	c.startBackground([]*partition{p})
+	c.merging.compaction = true
+	return c.merging.done, true
+}
+
+// FinishMerge swaps in the partition written by a completed merge or compaction,
+// returning the number of bytes reclaimed and whether it was a compaction
+func (c *Collector) FinishMerge() (reclaimed int64, compacted bool) {
+	compaction := c.merging != nil && c.merging.compaction
+	reclaimed, swapped := c.finishMerge(false)
+	c.startMerge()
+	return reclaimed, compaction && swapped
+}
+
+// mostTombstones returns the partition on disk with the most tombstones, as long as it has at least min
+func (c *Collector) mostTombstones(min int) *partition {
+	if min < 1 {
+		min = 1
+	}
+
+	var most *partition
+	for _, p := range c.disk {
+		if n := p.impl.Tombstones(); n >= min && (most == nil || n > most.impl.Tombstones()) {
+			most = p
+		}
+	}
+	return most
+}
@@ -1,132 +1,183 @@
 package partition
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/binary"
-	"flash/tools/readers"
+	"errors"
 	"fmt"
-	"io"
-	"os"
 	"sort"
 )
 
-// Dictionary can be used to lookup file offsets for given keys
+// Dictionary can be used to lookup file offsets for given keys. It holds the offset of
+// each block of the partition, and is searched by comparing the full keys which start
+// the blocks. The offsets are mapped from the dictionary file rather than loaded, so
+// lookups only read the blocks they need from disk
 type Dictionary struct {
-	target    string
-	blockSize int64
-	entries   map[string]int64
-	keys      []string
+	target  string
+	data    *mapping
+	file    *mapping
+	offsets []byte
 }
 
-func loadDictionary(target string, blockSize int64) *Dictionary {
+func loadDictionary(target string, data *mapping) *Dictionary {
 	d := Dictionary{
-		target:    target,
-		blockSize: blockSize,
-		entries:   make(map[string]int64),
+		target: target,
+		data:   data,
 	}
 
-	_, err := os.Stat(d.getPath())
-	if err != nil {
-		d.calculateOffsets()
-		d.dump()
-	} else {
-		d.loadOffsets()
-	}
-
-	d.keys = make([]string, 0, len(d.entries))
-	for key := range d.entries {
-		d.keys = append(d.keys, key)
+	// Dictionaries can always be rebuilt from the partition, so rather
+	// than failing, recalculate them if they are missing or outdated
+	if err := d.loadOffsets(); err != nil {
+		offsets := d.calculateOffsets()
+		if err := d.dump(offsets); err != nil || d.loadOffsets() != nil {
+			d.offsets = offsets
+		}
 	}
-	sort.Strings(d.keys)
 
 	return &d
 }
 
+// getBuffer looks up the key in the partition's mapping. The buffer refers directly to
+// the mapping, so it's only valid while the partition is in use
 func (d *Dictionary) getBuffer(key string) (*bytes.Buffer, bool) {
-	reader := NewReader(d.target)
-	defer reader.Close()
+	n := d.block(key)
+	if n == -1 {
+		return nil, false
+	}
 
-	if offset, ok := d.entries[key]; ok {
-		_, buf := reader.fetchEntry(offset)
-		return buf, true
+	end := int64(len(d.data.data))
+	if n+1 < d.numBlocks() {
+		end = d.blockOffset(n + 1)
 	}
+	return d.findEntry(key, d.blockOffset(n), end)
+}
 
-	pos := sort.SearchStrings(d.keys, key) - 1
-	if pos == -1 || pos == len(d.keys)-1 {
-		return nil, false
+// seek returns the offset of the block which holds the first key that isn't before the given key
+func (d *Dictionary) seek(key string) int64 {
+	n := d.block(key)
+	if n == -1 {
+		return headerSize
 	}
+	return d.blockOffset(n)
+}
+
+// block returns the last block which starts with a key that isn't after the given key,
+// or -1 if every block starts after it
+func (d *Dictionary) block(key string) int {
+	return sort.Search(d.numBlocks(), func(n int) bool {
+		first, _, _ := d.data.key(d.blockOffset(n), "")
+		return first > key
+	}) - 1
+}
+
+func (d *Dictionary) numBlocks() int {
+	return len(d.offsets) / 8
+}
 
-	start := d.entries[d.keys[pos]]
-	end := d.entries[d.keys[pos+1]]
+func (d *Dictionary) blockOffset(n int) int64 {
+	return int64(binary.LittleEndian.Uint64(d.offsets[n*8:]))
+}
 
-	if buf, ok := reader.findEntry(key, start, end); ok {
-		return buf, true
+// findEntry reads the entries of a block until it finds the key, or passes where it would be
+func (d *Dictionary) findEntry(key string, start int64, end int64) (*bytes.Buffer, bool) {
+	prev := ""
+	for offset := start; offset < end; {
+		k, next, ok := d.data.key(offset, prev)
+		if !ok || k > key {
+			return nil, false
+		}
+		data, next, ok := d.data.field(next)
+		if !ok {
+			return nil, false
+		}
+
+		if k == key {
+			return bytes.NewBuffer(data), true
+		}
+		prev, offset = k, next
 	}
 
 	return nil, false
 }
 
-func (d *Dictionary) loadOffsets() {
-	f, err := os.Open(d.getPath())
+// loadOffsets maps the dictionary file
+func (d *Dictionary) loadOffsets() error {
+	m, err := openMapping(d.getPath())
 	if err != nil {
-		fmt.Println("Could not open dictionary file")
-		return
+		return err
 	}
-	defer f.Close()
-	r := bufio.NewReader(f)
-	numKeys := readers.ReadUint32(r)
-	for i := uint32(0); i < numKeys; i++ {
-		klen := readers.ReadUint32(r)
 
-		kbuf := make([]byte, klen)
-		io.ReadFull(r, kbuf)
-
-		offset := readers.ReadUint64(r)
-		d.entries[string(kbuf)] = int64(offset)
+	header, ok := m.slice(0, headerSize)
+	if !ok {
+		m.release()
+		return errors.New("dictionary is truncated")
+	}
+	if err := ReadHeader(bytes.NewReader(header), d.getPath()); err != nil {
+		m.release()
+		return err
 	}
+	if (len(m.data)-headerSize)%8 != 0 {
+		m.release()
+		return errors.New("dictionary is truncated")
+	}
+
+	d.file = m
+	d.offsets = m.data[headerSize:]
+	return nil
 }
 
-func (d *Dictionary) calculateOffsets() {
-	reader := NewReader(d.target)
-
-	var remainingBytes int64
-	var offset int64
-	for {
-		numBytes := int64(len(reader.currentKey)) + int64(reader.FetchDataLength()) + 8 // 8 bytes used for offsets
-		remainingBytes -= numBytes
-		if remainingBytes <= 0 {
-			d.entries[reader.currentKey] = offset
-			remainingBytes = d.blockSize
+// calculateOffsets finds the blocks of the partition. Any key which shares nothing
+// with the previous one can start a block, and like the writer, one is used once the
+// block before it has at least blockSize bytes
+func (d *Dictionary) calculateOffsets() []byte {
+	var offsets []byte
+	buf := make([]byte, 8)
+	blockStart := int64(headerSize - blockSize)
+
+	prev := ""
+	for offset := int64(headerSize); ; {
+		shared, _, ok := d.data.uvarint(offset)
+		if !ok {
+			return offsets
+		}
+		if shared == 0 && offset-blockStart >= blockSize {
+			blockStart = offset
+			binary.LittleEndian.PutUint64(buf, uint64(offset))
+			offsets = append(offsets, buf...)
 		}
 
-		reader.SkipData()
-		reader.NextKey()
-		offset += numBytes
-
-		if reader.done {
-			d.entries[reader.currentKey] = offset - numBytes
-			return
+		key, next, ok := d.data.key(offset, prev)
+		if !ok {
+			return offsets
 		}
+		_, next, ok = d.data.field(next)
+		if !ok {
+			return offsets
+		}
+		prev, offset = key, next
 	}
 }
 
-func (d *Dictionary) dump() {
-	f, err := os.Create(d.getPath())
-	if err != nil {
+func (d *Dictionary) dump(offsets []byte) error {
+	buf := new(bytes.Buffer)
+	WriteHeader(buf)
+	buf.Write(offsets)
+
+	forgetMapping(d.getPath())
+	if err := WriteFile(d.getPath(), buf.Bytes()); err != nil {
 		fmt.Println(err)
+		return err
 	}
-	defer f.Close()
+	return nil
+}
 
-	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, uint32(len(d.entries)))
-	for key, offset := range d.entries {
-		binary.Write(buf, binary.LittleEndian, uint32(len(key)))
-		binary.Write(buf, binary.LittleEndian, []byte(key))
-		binary.Write(buf, binary.LittleEndian, uint64(offset))
+// close releases the mapping of the dictionary file
+func (d *Dictionary) close() {
+	d.offsets = nil
+	if d.file != nil {
+		d.file.release()
+		d.file = nil
 	}
-
-	buf.WriteTo(f)
 }
 
 func (d *Dictionary) getPath() string {
@@ -0,0 +1,84 @@
+package partition
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Magic is written at the start of every index file, followed by the format version
+const Magic uint32 = 0x48534c46 // "FLSH"
+
+// Version is the current version of the on-disk format
+const Version uint32 = 5
+
+const headerSize = 8
+
+// ErrIncompatible is returned when a file was written using a different format version
+var ErrIncompatible = errors.New("incompatible index format")
+
+// WriteHeader writes the magic number and current format version
+func WriteHeader(w io.Writer) error {
+	return writeHeader(w, Version)
+}
+
+func writeHeader(w io.Writer, version uint32) error {
+	buf := make([]byte, headerSize)
+	binary.LittleEndian.PutUint32(buf[0:4], Magic)
+	binary.LittleEndian.PutUint32(buf[4:8], version)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadHeader reads the header of the given file, returning an error if it was
+// written using a different format version
+func ReadHeader(r io.Reader, path string) error {
+	version, err := readVersion(r)
+	if err != nil {
+		return fmt.Errorf("%v: %w", path, err)
+	}
+
+	if version != Version {
+		return fmt.Errorf("%v uses format version %d, expected %d: %w", path, version, Version, ErrIncompatible)
+	}
+	return nil
+}
+
+// FileVersion returns the format version of the file at the given path. Files
+// written before versioning was introduced are reported as version 0
+func FileVersion(path string) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	version, err := readVersion(f)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return 0, nil
+	}
+	return version, err
+}
+
+// CollectorVersion returns the format version of the collector with the given extension
+func CollectorVersion(dir, extension string) (uint32, error) {
+	version, err := FileVersion(fmt.Sprintf("%v/%v.manifest", dir, extension))
+	if os.IsNotExist(err) {
+		return FileVersion(fmt.Sprintf("%v/%v.info", dir, extension))
+	}
+	return version, err
+}
+
+func readVersion(r io.Reader) (uint32, error) {
+	buf := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, err
+	}
+
+	if binary.LittleEndian.Uint32(buf[0:4]) != Magic {
+		return 0, nil
+	}
+	return binary.LittleEndian.Uint32(buf[4:8]), nil
+}
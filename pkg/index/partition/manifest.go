@@ -0,0 +1,140 @@
+package partition
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// The manifest lists the generations of the partitions which are live, along with
+// the sequence number of the journal. It is always replaced atomically, so any
+// partition files which it doesn't list are left over from an interrupted write
+func (c *Collector) getManifestPath() string {
+	return fmt.Sprintf("%v/%v.manifest", c.dir, c.extension)
+}
+
+func (c *Collector) writeManifest() error {
+	buf := new(bytes.Buffer)
+	WriteHeader(buf)
+	binary.Write(buf, binary.LittleEndian, c.seq)
+	for p := range c.disk {
+		binary.Write(buf, binary.LittleEndian, uint32(c.disk[p].generation))
+	}
+	return WriteFile(c.getManifestPath(), buf.Bytes())
+}
+
+func (c *Collector) loadManifest() error {
+	path := c.getManifestPath()
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		// Indexes written before the manifest was introduced used an info file
+		if _, infoErr := os.Stat(c.getLegacyInfoPath()); infoErr == nil {
+			return fmt.Errorf("%v uses an older format: %w", c.getLegacyInfoPath(), ErrIncompatible)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	if err := ReadHeader(reader, path); err != nil {
+		return err
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &c.seq); err != nil {
+		return fmt.Errorf("%v is truncated: %w", path, err)
+	}
+
+	buf := make([]byte, 4)
+	for {
+		n, err := io.ReadFull(reader, buf)
+		if n == 0 || err != nil {
+			break
+		}
+
+		gen := int(binary.LittleEndian.Uint32(buf))
+		part, err := loadPartition(c.dir, c.extension, gen, c.newImplementation())
+		if err != nil {
+			return err
+		}
+		c.disk = append(c.disk, part)
+		if gen > c.generation {
+			c.generation = gen
+		}
+	}
+	return nil
+}
+
+func (c *Collector) getLegacyInfoPath() string {
+	return fmt.Sprintf("%v/%v.info", c.dir, c.extension)
+}
+
+// removeOrphans removes any partition files which aren't listed in the manifest
+func (c *Collector) removeOrphans() {
+	live := make(map[string]bool)
+	for _, p := range c.disk {
+		live[filepath.Base(p.getPath())] = true
+		live[filepath.Base(p.getPath())+".dict"] = true
+		live[filepath.Base(p.getInfoPath())] = true
+	}
+
+	ext := regexp.QuoteMeta(c.extension)
+	partitionFile := regexp.MustCompile(`^(part_\d+|temp)\.` + ext + `(\.dict|\.info)?(\.temp|\.migrate)?$`)
+	tempFile := regexp.MustCompile(`^` + ext + `\.(manifest|wal)\.(temp|migrate)$`)
+
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+
+	for _, f := range files {
+		orphaned := partitionFile.MatchString(f.Name()) || tempFile.MatchString(f.Name())
+		if orphaned && !live[f.Name()] {
+			fmt.Println("Removing orphaned file", f.Name())
+			os.Remove(filepath.Join(c.dir, f.Name()))
+		}
+	}
+}
+
+// WriteFile atomically replaces the file at the given path, by writing the data to a
+// temporary file which is synced to disk and then renamed
+func WriteFile(path string, data []byte) error {
+	temp := path + ".temp"
+	f, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(temp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir syncs a directory, ensuring that renames and removals within it are durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
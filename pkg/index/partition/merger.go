@@ -1,40 +1,46 @@
 package partition
 
 import (
-	"bytes"
-	"encoding/binary"
+	"bufio"
 	"log"
 	"os"
 )
 
 type merger struct {
-	dir        string
-	output     *os.File
-	part       *partition
-	partitions []*partition
-	readers    []*Reader
-	finished   int
+	dir      string
+	output   *os.File
+	writer   *bufio.Writer
+	entries  *entryWriter
+	part     *partition
+	impls    []Implementation
+	readers  []*Reader
+	finished int
 }
 
-func merge(partitions []*partition, out *partition) {
-	m := merger{dir: out.indexpath, partitions: partitions, part: out}
+// merge writes the entries of the readers into the output partition, using the
+// implementations to decide which entries are valid. The readers are closed once read
+func merge(readers []*Reader, impls []Implementation, out *partition) {
+	m := merger{dir: out.indexpath, readers: readers, impls: impls, part: out}
 	m.createOutputFile()
 	defer m.output.Close()
 
-	m.openReaders(partitions)
+	for _, r := range readers {
+		if r.done {
+			m.finished++
+		}
+	}
 	for m.finished < len(m.readers) {
 		term, readers, impls := m.getNextTerm()
-
-		buf := new(bytes.Buffer)
-		binary.Write(buf, binary.LittleEndian, uint32(len(term)))
-		binary.Write(buf, binary.LittleEndian, []byte(term))
-		buf.WriteTo(m.output)
-
-		m.mergeData(readers, impls)
+		m.mergeData(term, readers, impls)
 		m.advanceTerms(readers)
 	}
 
-	m.deletePartitionFiles()
+	if err := m.writer.Flush(); err != nil {
+		log.Fatal("Could not write index file")
+	}
+	if err := m.output.Sync(); err != nil {
+		log.Fatal("Could not sync index file")
+	}
 }
 
 func (m *merger) getNextTerm() (term string, readers []*Reader, impls []Implementation) {
@@ -50,21 +56,23 @@ func (m *merger) getNextTerm() (term string, readers []*Reader, impls []Implemen
 			impls = impls[:0]
 
 			readers = append(readers, m.readers[i])
-			impls = append(impls, m.partitions[i].impl)
+			impls = append(impls, m.impls[i])
 		} else if cmp == 0 { // If the current term is equal to the selected term
 			readers = append(readers, m.readers[i])
-			impls = append(impls, m.partitions[i].impl)
+			impls = append(impls, m.impls[i])
 		}
 	}
 	return term, readers, impls
 }
 
-func (m *merger) mergeData(readers []*Reader, impls []Implementation) {
-	if merged := m.part.impl.Merge(readers, impls); merged != nil {
-		buf := merged.Bytes()
-		binary.Write(m.output, binary.LittleEndian, uint32(buf.Len()))
-		m.output.Write(buf.Bytes())
+// mergeData writes the merged entry for the term, terms without any valid entries are left out
+func (m *merger) mergeData(term string, readers []*Reader, impls []Implementation) {
+	merged := m.part.impl.Merge(readers, impls)
+	if merged == nil {
+		return
 	}
+
+	m.entries.write(term, merged.Bytes().Bytes())
 }
 
 func (m *merger) advanceTerms(readers []*Reader) {
@@ -81,21 +89,9 @@ func (m *merger) createOutputFile() {
 	if err != nil {
 		log.Fatal("Could not create index file")
 	}
+	forgetMapping(path)
 	m.output = f
-}
-
-func (m *merger) openReaders(partitions []*partition) {
-	for i := range partitions {
-		m.readers = append(m.readers, NewReader(partitions[i].getPath()))
-	}
-}
-
-func (m *merger) deletePartitionFiles() {
-	for i := range m.partitions {
-		os.Remove(m.partitions[i].getPath())
-		// Remove old dictionaries of non-temp partitions
-		if m.partitions[i].generation != 0 {
-			os.Remove(m.partitions[i].dict.getPath())
-		}
-	}
+	m.writer = bufio.NewWriter(f)
+	m.entries = newEntryWriter(m.writer)
+	WriteHeader(m.writer)
 }
@@ -0,0 +1,455 @@
+package partition
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"flash/tools/readers"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// legacyTarget is the version that files written before versioning are upgraded to
+const legacyTarget = 1
+
+// MigrateLegacy upgrades the files of a collector which were written before the
+// format was versioned. If convert is not nil, every value is passed through it
+// before being rewritten. Dictionaries are removed, and rebuilt when next loaded
+func MigrateLegacy(dir, extension string, convert func(data []byte) []byte) error {
+	infoPath := fmt.Sprintf("%v/%v.info", dir, extension)
+	data, err := ioutil.ReadFile(infoPath)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i+4 <= len(data); i += 4 {
+		gen := int(binary.LittleEndian.Uint32(data[i : i+4]))
+		p := newPartition(dir, extension, gen, nil)
+
+		if err := migrateLegacyData(p.getPath(), convert); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := AddHeader(p.getInfoPath()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		os.Remove(fmt.Sprintf("%v.dict", p.getPath()))
+	}
+
+	return AddHeader(infoPath)
+}
+
+// AddHeader prepends a header to a file written before the format was versioned
+func AddHeader(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	writeHeader(buf, legacyTarget)
+	buf.Write(data)
+	return replaceFile(path, buf)
+}
+
+func migrateLegacyData(path string, convert func(data []byte) []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := new(bytes.Buffer)
+	writeHeader(buf, legacyTarget)
+	err = readEntries(bufio.NewReader(f), path, func(key, data []byte) {
+		if convert != nil {
+			data = convert(data)
+		}
+
+		binary.Write(buf, binary.LittleEndian, uint32(len(key)))
+		buf.Write(key)
+		binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+		buf.Write(data)
+	})
+	if err != nil {
+		return err
+	}
+
+	return replaceFile(path, buf)
+}
+
+// readEntries calls fn for each key and value in a partition file, without checking its header
+func readEntries(r *bufio.Reader, path string, fn func(key, data []byte)) error {
+	for {
+		keyLen := readers.ReadUint32(r)
+		key := make([]byte, keyLen)
+		if n, err := io.ReadFull(r, key); n == 0 || err != nil {
+			return nil
+		}
+
+		data := make([]byte, readers.ReadUint32(r))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return fmt.Errorf("%v is truncated: %w", path, err)
+		}
+		fn(key, data)
+	}
+}
+
+// manifestTarget is the version which introduced the manifest and journal sequence numbers
+const manifestTarget = 2
+
+// MigrateManifest replaces the info file of a collector with a manifest. The memory
+// partition, which was previously saved as a partition file, is moved into the journal
+func MigrateManifest(dir, extension string) error {
+	infoPath := fmt.Sprintf("%v/%v.info", dir, extension)
+	info, err := ioutil.ReadFile(infoPath)
+	if err != nil {
+		return err
+	}
+
+	manifest := new(bytes.Buffer)
+	writeHeader(manifest, manifestTarget)
+	binary.Write(manifest, binary.LittleEndian, uint32(0))
+
+	journal := new(bytes.Buffer)
+	writeHeader(journal, manifestTarget)
+	binary.Write(journal, binary.LittleEndian, uint32(0))
+
+	for i := headerSize; i+4 <= len(info); i += 4 {
+		gen := int(binary.LittleEndian.Uint32(info[i : i+4]))
+		p := newPartition(dir, extension, gen, nil)
+
+		if gen == 0 {
+			err := restoreEntries(p.getPath(), journal)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+
+		manifest.Write(info[i : i+4])
+		for _, path := range []string{p.getPath(), p.getInfoPath()} {
+			if err := SetVersion(path, manifestTarget); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		os.Remove(p.getPath() + ".dict")
+	}
+
+	// Operations journaled after the memory partition was saved are replayed after it
+	walPath := fmt.Sprintf("%v/%v.wal", dir, extension)
+	if wal, err := ioutil.ReadFile(walPath); err == nil && len(wal) > headerSize {
+		journal.Write(wal[headerSize:])
+	}
+
+	if err := WriteFile(walPath, journal.Bytes()); err != nil {
+		return err
+	}
+	if err := WriteFile(fmt.Sprintf("%v/%v.manifest", dir, extension), manifest.Bytes()); err != nil {
+		return err
+	}
+
+	temp := newPartition(dir, extension, 0, nil)
+	os.Remove(temp.getPath())
+	os.Remove(temp.getInfoPath())
+	return os.Remove(infoPath)
+}
+
+func restoreEntries(path string, journal *bytes.Buffer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	if _, err := r.Discard(headerSize); err != nil {
+		return nil
+	}
+	return readEntries(r, path, func(key, data []byte) {
+		write(journal, walRestore, string(key), data)
+	})
+}
+
+// SetVersion overwrites the version in the header of the given file
+func SetVersion(path string, version uint32) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, version)
+	if _, err := f.WriteAt(buf, 4); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func replaceFile(path string, buf *bytes.Buffer) error {
+	temp := path + ".migrate"
+	if err := ioutil.WriteFile(temp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
+}
+
+// blocksTarget is the version which front coded the keys of partition files in blocks
+const blocksTarget = 3
+
+// MigrateBlocks rewrites the partition files of a collector, front coding their keys in
+// blocks. Dictionaries are removed, and rebuilt from the blocks when next loaded
+func MigrateBlocks(dir, extension string) error {
+	c := &Collector{dir: dir, extension: extension}
+	manifest, err := ioutil.ReadFile(c.getManifestPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for i := headerSize + 4; i+4 <= len(manifest); i += 4 {
+		gen := int(binary.LittleEndian.Uint32(manifest[i : i+4]))
+		p := newPartition(dir, extension, gen, nil)
+
+		if err := migrateBlocksData(p.getPath()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := SetVersion(p.getInfoPath(), blocksTarget); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		os.Remove(p.getPath() + ".dict")
+	}
+
+	if err := SetVersion(c.getWALPath(), blocksTarget); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return SetVersion(c.getManifestPath(), blocksTarget)
+}
+
+func migrateBlocksData(path string) error {
+	// Files rewritten by an interrupted migration are already in the new format
+	if version, err := FileVersion(path); err != nil || version == blocksTarget {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	if _, err := r.Discard(headerSize); err != nil {
+		return nil
+	}
+
+	buf := new(bytes.Buffer)
+	writeHeader(buf, blocksTarget)
+	w := newEntryWriter(buf)
+	err = readEntries(r, path, func(key, data []byte) {
+		w.write(string(key), data)
+	})
+	if err != nil {
+		return err
+	}
+
+	return WriteFile(path, buf.Bytes())
+}
+
+// valuesTarget is the version which stored every field of a value, rather than leaving
+// out fields which were introduced after the value was written
+const valuesTarget = 4
+
+// MigrateValues rewrites the partition files and journal of a collector, passing every
+// value through convert. If convert is nil the values are unchanged, and only the
+// version of each file is updated
+func MigrateValues(dir, extension string, convert func(data []byte) []byte) error {
+	c := &Collector{dir: dir, extension: extension}
+	manifest, err := ioutil.ReadFile(c.getManifestPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for i := headerSize + 4; i+4 <= len(manifest); i += 4 {
+		gen := int(binary.LittleEndian.Uint32(manifest[i : i+4]))
+		p := newPartition(dir, extension, gen, nil)
+
+		if convert == nil {
+			err = SetVersion(p.getPath(), valuesTarget)
+		} else {
+			err = migrateValuesData(p.getPath(), convert)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := SetVersion(p.getInfoPath(), valuesTarget); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		os.Remove(p.getPath() + ".dict")
+	}
+
+	if convert == nil {
+		err = SetVersion(c.getWALPath(), valuesTarget)
+	} else {
+		err = migrateValuesWAL(c.getWALPath(), convert)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return SetVersion(c.getManifestPath(), valuesTarget)
+}
+
+func migrateValuesData(path string, convert func(data []byte) []byte) error {
+	// Files rewritten by an interrupted migration are already in the new format
+	if version, err := FileVersion(path); err != nil || version == valuesTarget {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	writeHeader(buf, valuesTarget)
+	w := newEntryWriter(buf)
+	err = readBlocks(path, data, func(key string, value []byte) {
+		w.write(key, convert(value))
+	})
+	if err != nil {
+		return err
+	}
+
+	return WriteFile(path, buf.Bytes())
+}
+
+// readBlocks calls fn for each key and value in the data of a partition file written in blocks
+func readBlocks(path string, data []byte, fn func(key string, value []byte)) error {
+	m := &mapping{path: path, data: data}
+	prev := ""
+	for offset := int64(headerSize); offset < int64(len(data)); {
+		key, next, ok := m.key(offset, prev)
+		if !ok {
+			return fmt.Errorf("%v is truncated", path)
+		}
+		value, next, ok := m.field(next)
+		if !ok {
+			return fmt.Errorf("%v is truncated", path)
+		}
+		fn(key, value)
+		prev, offset = key, next
+	}
+	return nil
+}
+
+func migrateValuesWAL(path string, convert func(data []byte) []byte) error {
+	if version, err := FileVersion(path); err != nil || version == valuesTarget {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The sequence number is kept, so that the journal is still replayed
+	r := bufio.NewReader(f)
+	header := make([]byte, headerSize+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil
+	}
+
+	buf := new(bytes.Buffer)
+	writeHeader(buf, valuesTarget)
+	buf.Write(header[headerSize:])
+	err = readOperations(r, func(op byte, key string, data []byte) {
+		if op != walDelete {
+			data = convert(data)
+		}
+		write(buf, op, key, data)
+	})
+	if err != nil {
+		return fmt.Errorf("%v %w", path, err)
+	}
+
+	return WriteFile(path, buf.Bytes())
+}
+
+// infoTarget is the version which recorded the documents held by each partition of the
+// postings in its info file
+const infoTarget = 5
+
+// MigrateInfo rewrites the info file of each partition of a collector. If extend isn't
+// nil, it's called with a function which reads every value of the partition, and what
+// it returns is appended to the info. Otherwise only the version of each file is updated
+func MigrateInfo(dir, extension string, extend func(values func(fn func(data []byte))) []byte) error {
+	c := &Collector{dir: dir, extension: extension}
+	manifest, err := ioutil.ReadFile(c.getManifestPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for i := headerSize + 4; i+4 <= len(manifest); i += 4 {
+		gen := int(binary.LittleEndian.Uint32(manifest[i : i+4]))
+		p := newPartition(dir, extension, gen, nil)
+
+		if extend == nil {
+			err = SetVersion(p.getInfoPath(), infoTarget)
+		} else {
+			err = migrateInfoData(p, extend)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, path := range []string{p.getPath(), p.getPath() + ".dict"} {
+			if err := SetVersion(path, infoTarget); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	if err := SetVersion(c.getWALPath(), infoTarget); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return SetVersion(c.getManifestPath(), infoTarget)
+}
+
+func migrateInfoData(p *partition, extend func(values func(fn func(data []byte))) []byte) error {
+	// Infos rewritten by an interrupted migration are already in the new format
+	if version, err := FileVersion(p.getInfoPath()); err != nil || version == infoTarget {
+		return err
+	}
+
+	info, err := ioutil.ReadFile(p.getInfoPath())
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(p.getPath())
+	if err != nil {
+		return err
+	}
+
+	var readErr error
+	extra := extend(func(fn func(data []byte)) {
+		readErr = readBlocks(p.getPath(), data, func(key string, value []byte) {
+			fn(value)
+		})
+	})
+	if readErr != nil {
+		return readErr
+	}
+
+	buf := new(bytes.Buffer)
+	writeHeader(buf, infoTarget)
+	buf.Write(info[headerSize:])
+	buf.Write(extra)
+	return WriteFile(p.getInfoPath(), buf.Bytes())
+}
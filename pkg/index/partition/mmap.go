@@ -0,0 +1,143 @@
+package partition
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// mapping is a read only memory mapping of a partition file. Mappings are shared
+// by every reader of the file, and are unmapped once the last reference is released
+type mapping struct {
+	path string
+	info os.FileInfo
+	data []byte
+	refs int
+}
+
+// mappingPool holds the mapping of each open partition file, so that a file is
+// only opened and mapped once however many readers use it
+type mappingPool struct {
+	sync.Mutex
+	files map[string]*mapping
+}
+
+var mappings = &mappingPool{files: make(map[string]*mapping)}
+
+// openMapping returns the mapping of the file at the given path, mapping it if it isn't already open
+func openMapping(path string) (*mapping, error) {
+	mappings.Lock()
+	defer mappings.Unlock()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// The file may have been replaced since it was mapped, by another index or process
+	if m, ok := mappings.files[path]; ok && os.SameFile(m.info, stat) && m.info.Size() == stat.Size() {
+		m.refs++
+		return m, nil
+	}
+
+	m := &mapping{path: path, info: stat, refs: 1}
+	if stat.Size() > 0 {
+		m.data, err = syscall.Mmap(int(f.Fd()), 0, int(stat.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+		if err != nil {
+			return nil, fmt.Errorf("could not map %v: %w", path, err)
+		}
+	}
+
+	mappings.files[path] = m
+	return m, nil
+}
+
+// acquire adds a reference to the mapping, which must be released once it's no longer used
+func (m *mapping) acquire() *mapping {
+	mappings.Lock()
+	m.refs++
+	mappings.Unlock()
+	return m
+}
+
+// release removes a reference to the mapping, unmapping it if it was the last one
+func (m *mapping) release() {
+	mappings.Lock()
+	defer mappings.Unlock()
+
+	m.refs--
+	if m.refs > 0 {
+		return
+	}
+
+	if mappings.files[m.path] == m {
+		delete(mappings.files, m.path)
+	}
+	if m.data != nil {
+		syscall.Munmap(m.data)
+		m.data = nil
+	}
+}
+
+// slice returns the bytes between the offsets, or false if they're out of range. The
+// capacity is limited so that appending to the slice can't write into the mapping
+func (m *mapping) slice(start, end int64) ([]byte, bool) {
+	if start < 0 || end < start || end > int64(len(m.data)) {
+		return nil, false
+	}
+	return m.data[start:end:end], true
+}
+
+// uvarint returns the varint at the offset, and the offset after it
+func (m *mapping) uvarint(offset int64) (uint64, int64, bool) {
+	if offset < 0 || offset >= int64(len(m.data)) {
+		return 0, offset, false
+	}
+	v, n := binary.Uvarint(m.data[offset:])
+	if n <= 0 {
+		return 0, offset, false
+	}
+	return v, offset + int64(n), true
+}
+
+// field returns the length prefixed field at the offset, and the offset after it
+func (m *mapping) field(offset int64) ([]byte, int64, bool) {
+	length, start, ok := m.uvarint(offset)
+	if !ok {
+		return nil, offset, false
+	}
+
+	end := start + int64(length)
+	field, ok := m.slice(start, end)
+	return field, end, ok
+}
+
+// key returns the front coded key at the offset, given the key before it, and the
+// offset after it. The previous key is ignored at the start of a block
+func (m *mapping) key(offset int64, prev string) (string, int64, bool) {
+	shared, next, ok := m.uvarint(offset)
+	if !ok || shared > uint64(len(prev)) {
+		return "", offset, false
+	}
+	suffix, next, ok := m.field(next)
+	if !ok {
+		return "", offset, false
+	}
+	return prev[:shared] + string(suffix), next, true
+}
+
+// forgetMapping is called when the file at the path is replaced, so that it's mapped
+// again when it's next opened. Readers of the old file keep using its mapping
+func forgetMapping(path string) {
+	mappings.Lock()
+	delete(mappings.files, path)
+	mappings.Unlock()
+}
@@ -24,7 +24,15 @@ type Implementation interface {
 	LoadInfo(io.Reader)
 	GetInfo() *bytes.Buffer
 	Clear()
-	GC(*Reader, string) (size int)
+	Tombstones() int
+	MemoryUsage() int64
+}
+
+// Holder is implemented by partitions whose entries are deleted by keys other than
+// their own, such as the postings of a document, to report whether a partition on disk
+// has any entries which the key deletes
+type Holder interface {
+	Holds(key string) bool
 }
 
 // Entry is used as values inserted into the partitions
@@ -33,48 +41,39 @@ type Entry interface {
 }
 
 type partition struct {
-	indexpath         string
-	extension         string
-	generation        int
-	impl              Implementation
-	dict              *Dictionary
-	size              int
-	deleted           int
-	deletionThreshold float64
-	limit             int
+	indexpath  string
+	extension  string
+	generation int
+	impl       Implementation
+	dict       *Dictionary
+	data       *mapping
+	deleted    int
+	merging    bool
 }
 
-const dictionaryLimit = 1 << 20
-
-func newPartition(indexpath, extension string, generation, limit int, impl Implementation) *partition {
+func newPartition(indexpath, extension string, generation int, impl Implementation) *partition {
 	p := partition{
-		indexpath:         indexpath,
-		extension:         extension,
-		generation:        generation,
-		impl:              impl,
-		limit:             limit,
-		deletionThreshold: float64(generation*limit) * 0.1,
+		indexpath:  indexpath,
+		extension:  extension,
+		generation: generation,
+		impl:       impl,
 	}
 
 	return &p
 }
 
-func loadPartition(indexpath, extension string, generation, limit int, impl Implementation) *partition {
-	p := newPartition(indexpath, extension, generation, limit, impl)
-	p.loadInfo()
-
-	if generation == 0 {
-		p.loadData()
-	} else {
-		p.dict = loadDictionary(p.getPath(), dictionaryLimit)
+func loadPartition(indexpath, extension string, generation int, impl Implementation) (*partition, error) {
+	p := newPartition(indexpath, extension, generation, impl)
+	if err := p.loadInfo(); err != nil {
+		return nil, err
 	}
 
-	return p
+	p.loadDict()
+	return p, nil
 }
 
 func (p *partition) updateGeneration(gen int) {
 	p.generation = gen
-	p.deletionThreshold = float64(gen*p.limit) * 0.1
 }
 
 func (p *partition) getBuffer(key string) (*bytes.Buffer, bool) {
@@ -99,28 +98,74 @@ func (p *partition) getEntry(key string) (Entry, bool) {
 	return nil, false
 }
 
+// scanRange calls fn with every entry whose key is at least start and before end, or
+// with every key from start if end is empty. Partitions on disk are sorted by key, so
+// the scan starts from the dictionary block of the first match
+func (p *partition) scanRange(start, end string, fn func(key string, val Entry)) {
+	inRange := func(key string) bool {
+		return key >= start && (end == "" || key < end)
+	}
+
+	if p.generation == 0 {
+		for _, key := range p.impl.Keys() {
+			if inRange(key) {
+				if val, ok := p.impl.Get(key); ok {
+					fn(key, val)
+				}
+			}
+		}
+		return
+	}
+
+	r := p.newReader()
+	defer r.Close()
+	r.seek(p.dict.seek(start))
+	for ; !r.done; r.NextKey() {
+		r.FetchDataLength()
+		if r.currentKey < start {
+			r.SkipData()
+			continue
+		}
+		if !inRange(r.currentKey) {
+			return
+		}
+		if val, ok := p.impl.Decode(r.currentKey, r.FetchData()); ok {
+			fn(r.currentKey, val)
+		}
+	}
+}
+
+// prefixEnd returns the first key after every key with the prefix, or an empty string if there isn't one
+func prefixEnd(prefix string) string {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] < 0xff {
+			return prefix[:i] + string([]byte{prefix[i] + 1})
+		}
+	}
+	return ""
+}
+
 func (p *partition) add(key string, val Entry) {
 	p.impl.Add(key, val)
-	p.size++
 }
 
+// delete removes the key from the partition. Partitions on disk only record a tombstone
+// if they hold the key, so that they aren't compacted for deletes of other partitions
 func (p *partition) delete(key string) {
+	if p.generation != 0 && !p.holds(key) {
+		return
+	}
 	p.impl.Delete(key)
 	p.deleted++
-	p.size--
-
-	if p.deleted > int(p.deletionThreshold) && p.generation != 0 {
-		preader := NewReader(p.getPath())
-		p.size = p.impl.GC(preader, p.getPath()+".temp")
-		os.Rename(p.getPath()+".temp", p.getPath())
-		os.Remove(p.dict.getPath())
-		p.loadDict()
-		p.deleted = 0
-	}
 }
 
-func (p *partition) full() bool {
-	return p.size >= p.limit
+// holds returns true if the partition has an entry which the key deletes
+func (p *partition) holds(key string) bool {
+	if h, ok := p.impl.(Holder); ok {
+		return h.Holds(key)
+	}
+	_, ok := p.getBuffer(key)
+	return ok
 }
 
 func (p *partition) dump() {
@@ -129,8 +174,11 @@ func (p *partition) dump() {
 		log.Fatal("Could not create index partition")
 	}
 	defer f.Close()
+	forgetMapping(p.getPath())
 
+	WriteHeader(f)
 	p.bytes().WriteTo(f)
+	f.Sync()
 	p.impl.Clear()
 }
 
@@ -139,70 +187,80 @@ func (p *partition) bytes() *bytes.Buffer {
 	sort.Strings(keys)
 
 	buf := new(bytes.Buffer)
+	w := newEntryWriter(buf)
 	for _, key := range keys {
 		data, _ := p.impl.Get(key)
-		dataBuf := data.Bytes()
-
-		binary.Write(buf, binary.LittleEndian, uint32(len(key)))
-		binary.Write(buf, binary.LittleEndian, []byte(key))
-		binary.Write(buf, binary.LittleEndian, uint32(dataBuf.Len()))
-		binary.Write(buf, binary.LittleEndian, dataBuf.Bytes())
+		w.write(key, data.Bytes().Bytes())
 	}
 
 	return buf
 }
 
-func (p *partition) loadData() {
-	reader := NewReader(p.getPath())
-	defer reader.Close()
-
-	for !reader.done {
-		key := reader.currentKey
-		reader.FetchDataLength()
-		buf := reader.FetchData()
+// loadDict maps the partition file and loads its dictionary, it's called whenever the file is rewritten
+func (p *partition) loadDict() {
+	m, err := openMapping(p.getPath())
+	if err != nil {
+		log.Fatalf("Could not open file: %v\n", p.getPath())
+	}
+	p.closeData()
+	p.data = m
+	p.dict = loadDictionary(p.getPath(), m)
+}
 
-		if val, ok := p.impl.Decode(key, buf); ok {
-			p.add(key, val)
-		}
-		reader.NextKey()
+// newReader returns a reader which shares the partition's mapping
+func (p *partition) newReader() *Reader {
+	if p.data == nil {
+		return NewReader(p.getPath())
 	}
+	return newMappedReader(p.data.acquire())
 }
 
-func (p *partition) loadDict() {
-	p.dict = loadDictionary(p.getPath(), dictionaryLimit)
+// closeData releases the partition's mappings, which are unmapped once any readers using them are closed
+func (p *partition) closeData() {
+	if p.dict != nil {
+		p.dict.close()
+	}
+	if p.data != nil {
+		p.data.release()
+		p.data = nil
+	}
 }
 
-func (p *partition) loadInfo() {
+func (p *partition) loadInfo() error {
 	f, err := os.Open(p.getInfoPath())
 	if err != nil {
 		fmt.Println(err)
+		return nil
 	}
 	defer f.Close()
 
 	r := bufio.NewReader(f)
+	if err := ReadHeader(r, p.getInfoPath()); err != nil {
+		return err
+	}
 	p.deleted = int(readers.ReadUint32(r))
 	p.impl.LoadInfo(r)
+	return nil
 }
 
 func (p *partition) dumpInfo() {
-	path := fmt.Sprintf(p.getInfoPath())
-	f, err := os.Create(path)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-	defer f.Close()
-
 	buf := new(bytes.Buffer)
+	WriteHeader(buf)
 	binary.Write(buf, binary.LittleEndian, uint32(p.deleted))
 	binary.Write(buf, binary.LittleEndian, p.impl.GetInfo().Bytes())
-	buf.WriteTo(f)
+
+	if err := WriteFile(p.getInfoPath(), buf.Bytes()); err != nil {
+		fmt.Println(err)
+	}
 }
 
 func (p *partition) deleteFiles() {
+	p.closeData()
+	forgetMapping(p.getPath())
 	os.Remove(p.getPath())
-	os.Remove(p.dict.getPath())
+	os.Remove(p.getPath() + ".dict")
 	os.Remove(p.getInfoPath())
+	syncDir(p.indexpath)
 }
 
 func (p *partition) getPath() string {
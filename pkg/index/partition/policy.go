@@ -0,0 +1,210 @@
+package partition
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// MergePolicy decides which partitions on disk are merged. Partitions are grouped
+// into tiers by size, each tier holding partitions up to Factor times larger than
+// those in the tier below, and once a tier has Factor partitions they are merged
+// into a single partition. Partitions over half of MaxSegmentSize are never merged
+type MergePolicy struct {
+	Factor         int
+	MaxSegmentSize int64
+}
+
+// DefaultMergePolicy is used by collectors unless another policy is set
+var DefaultMergePolicy = MergePolicy{Factor: 10, MaxSegmentSize: 5 << 30}
+
+// mergeFloor is the size of the lowest tier, smaller partitions are treated as being this size
+const mergeFloor = 1 << 20
+
+// SetMergePolicy changes the policy used to merge the collector's partitions. Zero
+// values are replaced by those of the default policy
+func (c *Collector) SetMergePolicy(policy MergePolicy) {
+	if policy.Factor < 2 {
+		policy.Factor = DefaultMergePolicy.Factor
+	}
+	if policy.MaxSegmentSize <= 0 {
+		policy.MaxSegmentSize = DefaultMergePolicy.MaxSegmentSize
+	}
+	c.policy = policy
+}
+
+func (mp MergePolicy) tier(size int64) int {
+	t := 0
+	for limit := int64(mergeFloor); size > limit; limit *= int64(mp.Factor) {
+		t++
+	}
+	return t
+}
+
+// selectMerge returns the partitions which should be merged next, starting from the
+// lowest tier. Nil is returned if no tier is full
+func (mp MergePolicy) selectMerge(parts []*partition) []*partition {
+	tiers := make(map[int][]*partition)
+	for _, p := range parts {
+		if size := p.fileSize(); size <= mp.MaxSegmentSize/2 {
+			tiers[mp.tier(size)] = append(tiers[mp.tier(size)], p)
+		}
+	}
+
+	var levels []int
+	for t := range tiers {
+		levels = append(levels, t)
+	}
+	sort.Ints(levels)
+
+	for _, t := range levels {
+		candidates := tiers[t]
+		if len(candidates) < mp.Factor {
+			continue
+		}
+		sort.Slice(candidates, func(a, b int) bool {
+			return candidates[a].fileSize() < candidates[b].fileSize()
+		})
+
+		var selected []*partition
+		var total int64
+		for _, p := range candidates[:mp.Factor] {
+			if total+p.fileSize() > mp.MaxSegmentSize {
+				break
+			}
+			selected = append(selected, p)
+			total += p.fileSize()
+		}
+		if len(selected) > 1 {
+			return selected
+		}
+	}
+	return nil
+}
+
+// backgroundMerge is a merge of a frozen set of partitions, which runs without holding
+// the collector. Until the merged partition is swapped in, the inputs are still searched
+type backgroundMerge struct {
+	inputs     []*partition
+	output     *partition
+	deleted    []string
+	done       chan struct{}
+	compaction bool
+}
+
+// startMerge begins merging the partitions chosen by the merge policy, unless a merge is already running
+func (c *Collector) startMerge() {
+	if c.merging != nil {
+		return
+	}
+	if inputs := c.policy.selectMerge(c.disk); inputs != nil {
+		c.startBackground(inputs)
+	}
+}
+
+// startBackground merges the inputs into a new partition in the background. A single
+// input is rewritten without its tombstoned entries
+func (c *Collector) startBackground(inputs []*partition) {
+	m := &backgroundMerge{
+		inputs: inputs,
+		output: newPartition(c.dir, c.extension, c.nextGeneration(), c.newImplementation()),
+		done:   make(chan struct{}),
+	}
+
+	// The merge reads copies of the inputs' tombstones, as the inputs can still be
+	// deleted from while it runs. Those deletes are applied to the output when it's swapped in
+	readers := make([]*Reader, len(inputs))
+	impls := make([]Implementation, len(inputs))
+	for i, p := range inputs {
+		p.merging = true
+		readers[i] = p.newReader()
+		impls[i] = c.newImplementation()
+		impls[i].LoadInfo(p.impl.GetInfo())
+	}
+
+	c.merging = m
+	go func() {
+		merge(readers, impls, m.output)
+		m.output.loadDict()
+		close(m.done)
+	}()
+}
+
+// finishMerge swaps in the output of the running merge once it's complete, returning
+// the number of bytes reclaimed and whether it was swapped in. If wait is false, it
+// returns immediately when the merge is still running
+func (c *Collector) finishMerge(wait bool) (int64, bool) {
+	m := c.merging
+	if m == nil {
+		return 0, false
+	}
+	if wait {
+		<-m.done
+	} else {
+		select {
+		case <-m.done:
+		default:
+			return 0, false
+		}
+	}
+	c.merging = nil
+
+	for _, key := range m.deleted {
+		m.output.delete(key)
+	}
+	m.output.dumpInfo()
+
+	merged := make(map[*partition]bool)
+	reclaimed := -m.output.fileSize()
+	for _, p := range m.inputs {
+		merged[p] = true
+		p.merging = false
+		reclaimed += p.fileSize()
+	}
+
+	previous := c.disk
+	c.disk = nil
+	for _, p := range previous {
+		if !merged[p] {
+			c.disk = append(c.disk, p)
+		}
+	}
+
+	// Partitions are only removed by compaction, once every entry in them has been deleted
+	empty := m.output.fileSize() <= headerSize
+	if !empty {
+		c.disk = append(c.disk, m.output)
+	}
+
+	// The inputs are only removed once the manifest lists the merged partition
+	if err := c.writeManifest(); err != nil {
+		fmt.Println(err)
+		c.disk = previous
+		m.output.deleteFiles()
+		return 0, false
+	}
+	for _, p := range m.inputs {
+		p.deleteFiles()
+	}
+	if empty {
+		m.output.deleteFiles()
+	}
+	return reclaimed, true
+}
+
+// nextGeneration returns an unused generation for a new partition
+func (c *Collector) nextGeneration() int {
+	c.generation++
+	return c.generation
+}
+
+// fileSize returns the size of the partition's file
+func (p *partition) fileSize() int64 {
+	if p.data != nil {
+		return int64(len(p.data.data))
+	}
+	if info, err := os.Stat(p.getPath()); err == nil {
+		return info.Size()
+	}
+	return 0
+}
@@ -2,17 +2,15 @@ package partition
 
 import (
 	"bytes"
-	"encoding/binary"
-	"flash/tools/readers"
-	"io"
 	"log"
-	"os"
 	"strings"
 )
 
-// Reader for processing partitions
+// Reader for processing partitions. Each reader has its own position in the
+// partition's shared mapping, so separate readers can be used concurrently
 type Reader struct {
-	file       *os.File
+	data       *mapping
+	pos        int64
 	currentKey string
 	dataLength uint32
 	done       bool
@@ -20,13 +18,26 @@ type Reader struct {
 
 // NewReader creates a new partition reader
 func NewReader(target string) *Reader {
-	f, err := os.Open(target)
+	m, err := openMapping(target)
 	if err != nil {
 		log.Fatalf("Could not open file: %v\n", target)
 	}
+	return newMappedReader(m)
+}
+
+// newMappedReader creates a reader which takes ownership of a reference to the mapping
+func newMappedReader(m *mapping) *Reader {
+	header, ok := m.slice(0, headerSize)
+	if !ok {
+		header = m.data
+	}
+	if err := ReadHeader(bytes.NewReader(header), m.path); err != nil {
+		log.Fatal(err)
+	}
 
 	r := &Reader{
-		file: f,
+		data: m,
+		pos:  headerSize,
 		done: false,
 	}
 
@@ -40,16 +51,14 @@ func (r *Reader) NextKey() (ok bool) {
 		return false
 	}
 
-	keyLen := readers.ReadUint32(r.file)
-	buf := make([]byte, keyLen)
-	n, err := io.ReadFull(r.file, buf)
-	if n == 0 || err != nil {
-		r.done = true
-		r.file.Close()
+	key, next, ok := r.data.key(r.pos, r.currentKey)
+	if !ok {
+		r.Close()
 		return false
 	}
 
-	r.currentKey = string(buf)
+	r.pos = next
+	r.currentKey = key
 	return true
 }
 
@@ -60,62 +69,40 @@ func (r *Reader) CurrentKey() string {
 
 // FetchDataLength reads the length of the data section for the current key
 func (r *Reader) FetchDataLength() uint32 {
-	r.dataLength = readers.ReadUint32(r.file)
+	r.dataLength = 0
+	if length, next, ok := r.data.uvarint(r.pos); ok {
+		r.dataLength = uint32(length)
+		r.pos = next
+	}
 	return r.dataLength
 }
 
-// FetchData reads in the data portion for the current key
+// FetchData returns the data portion for the current key, which refers directly to the mapping
 func (r *Reader) FetchData() *bytes.Buffer {
-	buf := make([]byte, r.dataLength)
-	io.ReadFull(r.file, buf)
+	buf, _ := r.data.slice(r.pos, r.pos+int64(r.dataLength))
+	r.pos += int64(r.dataLength)
 	return bytes.NewBuffer(buf)
 }
 
-// SkipData will seek the file past the data section without reading it
+// SkipData moves past the data section without reading it
 func (r *Reader) SkipData() {
-	r.file.Seek(int64(r.dataLength), os.SEEK_CUR)
+	r.pos += int64(r.dataLength)
 }
 
-func (r *Reader) fetchEntry(offset int64) (term string, buf *bytes.Buffer) {
-	r.file.Seek(offset, os.SEEK_SET)
-	r.NextKey()
-	r.FetchDataLength()
-	buf = r.FetchData()
-
-	return r.currentKey, buf
-}
-
-func (r *Reader) findEntry(key string, start int64, end int64) (buf *bytes.Buffer, ok bool) {
-	r.file.Seek(start, os.SEEK_SET)
-	blockSize := end - start
-
-	block := make([]byte, blockSize)
-	io.ReadFull(r.file, block)
-
-	i := int64(0)
-	for i < blockSize {
-		keyLen := int64(binary.LittleEndian.Uint32(block[i : i+4]))
-		i += 4
-
-		k := string(block[i : i+keyLen])
-		i += keyLen
-
-		dataLen := int64(binary.LittleEndian.Uint32(block[i : i+4]))
-		i += 4
-
-		if k == key {
-			return bytes.NewBuffer(block[i : i+dataLen]), true
-		}
-
-		i += dataLen
+// seek moves the reader to the key at the start of the block at the given offset
+func (r *Reader) seek(offset int64) {
+	if r.done {
+		return
 	}
-
-	return nil, false
+	r.pos = offset
+	r.NextKey()
 }
 
-// Close the reader
+// Close the reader, releasing its reference to the mapping
 func (r *Reader) Close() {
-	r.file.Close()
+	if !r.done {
+		r.data.release()
+	}
 	r.done = true
 }
 
@@ -0,0 +1,138 @@
+package partition
+
+import (
+	"bytes"
+	"os"
+	"sort"
+)
+
+// CollectorStats describes the partitions of a collector
+type CollectorStats struct {
+	Extension  string
+	Partitions []PartitionStats
+}
+
+// PartitionStats describes a single partition. The memory partition has a generation of 0
+type PartitionStats struct {
+	Generation int
+	Keys       int
+	Bytes      int64
+	Tombstones int
+}
+
+// Stats returns statistics about each of the collector's partitions
+func (c *Collector) Stats() CollectorStats {
+	stats := CollectorStats{Extension: c.extension}
+	for _, p := range c.disk {
+		s := PartitionStats{
+			Generation: p.generation,
+			Tombstones: p.impl.Tombstones(),
+		}
+		if info, err := os.Stat(p.getPath()); err == nil {
+			s.Bytes = info.Size()
+		}
+
+		r := p.newReader()
+		for !r.done {
+			r.FetchDataLength()
+			r.SkipData()
+			s.Keys++
+			r.NextKey()
+		}
+		stats.Partitions = append(stats.Partitions, s)
+	}
+
+	sort.Slice(stats.Partitions, func(a, b int) bool {
+		return stats.Partitions[a].Generation < stats.Partitions[b].Generation
+	})
+
+	stats.Partitions = append(stats.Partitions, PartitionStats{
+		Keys:       len(c.memory.impl.Keys()),
+		Bytes:      c.memory.impl.MemoryUsage(),
+		Tombstones: c.memory.impl.Tombstones(),
+	})
+	return stats
+}
+
+// Scan calls fn for every key in the collector in sorted order, along with
+// the valid entries for the key from each of the partitions
+func (c *Collector) Scan(fn func(key string, entries []Entry)) {
+	var readers []*Reader
+	var impls []Implementation
+	for _, p := range c.disk {
+		readers = append(readers, p.newReader())
+		impls = append(impls, p.impl)
+	}
+
+	memKeys := c.memory.impl.Keys()
+	sort.Strings(memKeys)
+
+	m := 0
+	for {
+		key, found := "", false
+		for _, r := range readers {
+			if !r.done && (!found || r.compare(key) < 0) {
+				key, found = r.currentKey, true
+			}
+		}
+		if m < len(memKeys) && (!found || memKeys[m] < key) {
+			key, found = memKeys[m], true
+		}
+		if !found {
+			return
+		}
+
+		var entries []Entry
+		for i, r := range readers {
+			if r.done || r.currentKey != key {
+				continue
+			}
+			r.FetchDataLength()
+			if e, ok := impls[i].Decode(key, r.FetchData()); ok {
+				entries = append(entries, e)
+			}
+			r.NextKey()
+		}
+		if m < len(memKeys) && memKeys[m] == key {
+			if e, ok := c.memory.getEntry(key); ok {
+				entries = append(entries, e)
+			}
+			m++
+		}
+
+		fn(key, entries)
+	}
+}
+
+// Dump calls fn for every key in each partition, ordered by generation with the memory
+// partition last. All is the entry decoded without tombstones, and valid is nil if the
+// entry has been invalidated, so that deleted values can be inspected
+func (c *Collector) Dump(fn func(generation int, key string, all, valid Entry)) {
+	disk := append([]*partition(nil), c.disk...)
+	sort.Slice(disk, func(a, b int) bool { return disk[a].generation < disk[b].generation })
+
+	decoder := c.newImplementation()
+	for _, p := range disk {
+		for r := p.newReader(); !r.done; r.NextKey() {
+			r.FetchDataLength()
+			data := r.FetchData().Bytes()
+			all, _ := decoder.Decode(r.currentKey, bytes.NewBuffer(data))
+			valid, ok := p.impl.Decode(r.currentKey, bytes.NewBuffer(data))
+			if !ok {
+				valid = nil
+			}
+			fn(p.generation, r.currentKey, all, valid)
+		}
+	}
+
+	keys := c.memory.impl.Keys()
+	sort.Strings(keys)
+	for _, key := range keys {
+		all, _ := c.memory.impl.Get(key)
+		valid, ok := c.memory.getEntry(key)
+		if !ok {
+			valid = nil
+		}
+		fn(0, key, all, valid)
+	}
+}
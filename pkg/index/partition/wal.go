@@ -0,0 +1,175 @@
+package partition
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flash/tools/readers"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	walAdd byte = iota + 1
+	walDelete
+	walRestore
+)
+
+// wal journals the operations applied to the memory partition, so that they can
+// be replayed if the process exits before the partition is written to disk. Each
+// journal starts with a sequence number, which must match the manifest's for the
+// journal to be replayed
+type wal struct {
+	path   string
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func openWAL(path string, seq uint32) (*wal, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &wal{path: path, file: f, writer: bufio.NewWriter(f)}
+	if stat, err := f.Stat(); err == nil && stat.Size() == 0 {
+		w.writeHeader(w.writer, seq)
+	}
+	return w, nil
+}
+
+func (w *wal) writeHeader(writer io.Writer, seq uint32) {
+	WriteHeader(writer)
+	binary.Write(writer, binary.LittleEndian, seq)
+}
+
+func (w *wal) add(key string, val Entry) {
+	write(w.writer, walAdd, key, val.Bytes().Bytes())
+}
+
+func (w *wal) delete(key string) {
+	write(w.writer, walDelete, key, nil)
+}
+
+func write(writer io.Writer, op byte, key string, data []byte) {
+	binary.Write(writer, binary.LittleEndian, op)
+	binary.Write(writer, binary.LittleEndian, uint32(len(key)))
+	io.WriteString(writer, key)
+	binary.Write(writer, binary.LittleEndian, uint32(len(data)))
+	writer.Write(data)
+}
+
+// flush hands any buffered operations to the operating system
+func (w *wal) flush() error {
+	return w.writer.Flush()
+}
+
+// reset discards every journaled operation, starting a journal with the given sequence number
+func (w *wal) reset(seq uint32) error {
+	w.writer.Reset(w.file)
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	w.writeHeader(w.writer, seq)
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// compact atomically replaces the journal with the contents of the memory partition
+func (w *wal) compact(seq uint32, impl Implementation) error {
+	w.flush()
+
+	buf := new(bytes.Buffer)
+	w.writeHeader(buf, seq)
+	for _, key := range impl.Keys() {
+		if val, ok := impl.Get(key); ok {
+			write(buf, walRestore, key, val.Bytes().Bytes())
+		}
+	}
+
+	if err := WriteFile(w.path, buf.Bytes()); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.file.Close()
+	w.file = f
+	w.writer.Reset(f)
+	return nil
+}
+
+// replayWAL calls apply for each complete operation in the journal, a partially
+// written operation at the end of the file is ignored. The number of operations
+// replayed is returned, excluding those restoring a compacted memory partition
+func replayWAL(path string, seq uint32, apply func(op byte, key string, data *bytes.Buffer)) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var walSeq uint32
+	if err := ReadHeader(r, path); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &walSeq); err != nil || walSeq != seq {
+		fmt.Printf("Ignoring outdated journal %v\n", filepath.Base(path))
+		return 0, nil
+	}
+
+	num := 0
+	err = readOperations(r, func(op byte, key string, data []byte) {
+		if op == walAdd || op == walDelete {
+			num++
+		}
+		apply(op, key, bytes.NewBuffer(data))
+	})
+	if err != nil {
+		return num, fmt.Errorf("%v %w", path, err)
+	}
+	return num, nil
+}
+
+// readOperations calls fn for each complete operation read from the journal, stopping
+// at a partially written operation or one which is unknown
+func readOperations(r *bufio.Reader, fn func(op byte, key string, data []byte)) error {
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return nil
+		}
+
+		key := make([]byte, readers.ReadUint32(r))
+		if _, err := io.ReadFull(r, key); err != nil {
+			return nil
+		}
+
+		var dataLen uint32
+		if err := binary.Read(r, binary.LittleEndian, &dataLen); err != nil {
+			return nil
+		}
+		data := make([]byte, dataLen)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil
+		}
+
+		switch op {
+		case walAdd, walDelete, walRestore:
+		default:
+			return errors.New("contains an unknown operation")
+		}
+		fn(op, string(key), data)
+	}
+}
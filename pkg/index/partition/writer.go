@@ -0,0 +1,68 @@
+package partition
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// blockSize is the number of bytes of entries in each block of a partition file. Each
+// block starts with a full key, so that reading can start from it
+const blockSize = 16 << 10
+
+// entryWriter writes the entries of a partition file in key order. Each entry is the
+// number of bytes its key shares with the previous key, the rest of the key and the
+// value, with varint lengths. Keys at the start of a block share nothing
+type entryWriter struct {
+	w          io.Writer
+	offset     int64
+	blockStart int64
+	prev       string
+	scratch    [3 * binary.MaxVarintLen64]byte
+}
+
+// newEntryWriter creates a writer for the entries after the header of a partition file
+func newEntryWriter(w io.Writer) *entryWriter {
+	return &entryWriter{
+		w:          w,
+		offset:     headerSize,
+		blockStart: headerSize - blockSize,
+	}
+}
+
+func (e *entryWriter) write(key string, data []byte) error {
+	shared := 0
+	if e.offset-e.blockStart < blockSize {
+		shared = sharedPrefix(e.prev, key)
+	} else {
+		e.blockStart = e.offset
+	}
+
+	n := binary.PutUvarint(e.scratch[:], uint64(shared))
+	n += binary.PutUvarint(e.scratch[n:], uint64(len(key)-shared))
+	if _, err := e.w.Write(e.scratch[:n]); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(e.w, key[shared:]); err != nil {
+		return err
+	}
+	m := binary.PutUvarint(e.scratch[:], uint64(len(data)))
+	if _, err := e.w.Write(e.scratch[:m]); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(data); err != nil {
+		return err
+	}
+
+	e.offset += int64(n+len(key)-shared+m) + int64(len(data))
+	e.prev = key
+	return nil
+}
+
+// sharedPrefix returns the number of bytes at the start of both strings which are the same
+func sharedPrefix(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
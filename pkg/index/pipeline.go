@@ -0,0 +1,217 @@
+package index
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"flash/pkg/importer"
+	"flash/pkg/index/doclist"
+	"flash/tools/simhash"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/spf13/viper"
+)
+
+// extracted holds the terms of a file, which are ready to be inserted into the index
+type extracted struct {
+	device  uint64
+	inode   uint64
+	path    string
+	terms   map[string]uint32
+	length  uint32
+	hash    []byte
+	modTime int64
+	size    int64
+	meta    doclist.Metadata
+}
+
+// AddResult counts the files which were indexed by a call to Add, and those
+// which were skipped as they hadn't changed since they were last indexed
+type AddResult struct {
+	Indexed int
+	Skipped int
+}
+
+// addFiles extracts the text of each file using a pool of workers, taking
+// the write lock only while the results are inserted into the index. Done is
+// called from the workers after each file is indexed or skipped as unchanged
+func (i *Index) addFiles(paths <-chan string, lock *sync.RWMutex, done func(indexed bool)) {
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				doc, err := i.extract(path, lock)
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+				if doc == nil {
+					done(false)
+					continue
+				}
+
+				lock.Lock()
+				i.insert(doc)
+				lock.Unlock()
+				done(true)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// numWorkers returns the number of files which are extracted concurrently
+func numWorkers() int {
+	if n := viper.GetInt("workers"); n > 0 {
+		return n
+	}
+	return 1
+}
+
+// extract reads the terms of the file at the given path. If the file is already
+// in the index and hasn't changed, nil is returned without extracting any text
+func (i *Index) extract(path string, lock *sync.RWMutex) (*extracted, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &extracted{
+		path:    path,
+		terms:   make(map[string]uint32),
+		modTime: stat.ModTime().UnixNano(),
+		size:    stat.Size(),
+	}
+
+	if sys, ok := stat.Sys().(*syscall.Stat_t); ok {
+		doc.device = uint64(sys.Dev)
+		doc.inode = sys.Ino
+		doc.meta.Owner = sys.Uid
+	}
+
+	lock.RLock()
+	old, indexed := i.docs.FetchPath(path)
+	linked, hasLinked := i.docs.FetchInode(doc.device, doc.inode)
+	lock.RUnlock()
+
+	if indexed && old.ModTime() == doc.modTime && old.Size() == doc.size {
+		return nil, nil
+	}
+
+	// A file which was renamed without being modified keeps its postings
+	if !indexed && hasLinked && i.renamed(linked, path) && linked.ModTime() == doc.modTime && linked.Size() == doc.size {
+		lock.Lock()
+		moved := i.docs.Move(linked, path)
+		i.flush()
+		lock.Unlock()
+		if moved {
+			return nil, nil
+		}
+	}
+
+	// A file reached through a symbolic link is only indexed under one of its paths
+	if !indexed && hasLinked && linked.Path() != path && samePath(linked.Path(), path) {
+		return nil, nil
+	}
+
+	doc.hash, err = hashFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The file may have been touched or copied over without its content changing
+	if indexed && bytes.Equal(old.Hash(), doc.hash) {
+		lock.Lock()
+		i.docs.Touch(old, doc.modTime, doc.size)
+		i.flush()
+		lock.Unlock()
+		return nil, nil
+	}
+
+	words, meta := importer.GetText(path)
+	for term := range words {
+		doc.terms[term]++
+		doc.length++
+	}
+
+	doc.meta.MIME = meta.MIME
+	doc.meta.Title = meta.Title
+	doc.meta.Author = meta.Author
+	if !meta.Created.IsZero() {
+		doc.meta.Created = meta.Created.UnixNano()
+	}
+	return doc, nil
+}
+
+// insert adds the postings of an extracted file to the index, replacing any
+// previous version of the file. The caller must hold the write lock
+func (i *Index) insert(doc *extracted) {
+	id := i.identify(doc)
+	for term, freq := range doc.terms {
+		i.collector.Add(term, &postingEntry{docID: id, frequency: freq})
+	}
+
+	d := doclist.NewDocument(id, doc.path, doc.length)
+	d.SetContent(doc.hash, simhash.Hash(doc.terms))
+	d.SetStat(doc.modTime, doc.size)
+	d.SetInode(doc.device, doc.inode)
+	d.SetMetadata(doc.meta)
+	i.docs.Add(d)
+	i.flush()
+}
+
+// identify returns the id of the extracted file. A file keeps its id when it's updated
+// or renamed, in which case the previous version is removed. Hard links to a file
+// which is already indexed are given their own id, so that each path can be found
+func (i *Index) identify(doc *extracted) uint64 {
+	if old, ok := i.docs.FetchPath(doc.path); ok {
+		fmt.Println(doc.path, "already in the index, removing and readding")
+		i.deleteDoc(old)
+		return old.ID()
+	}
+
+	if linked, ok := i.docs.FetchInode(doc.device, doc.inode); ok {
+		if i.renamed(linked, doc.path) {
+			i.deleteDoc(linked)
+			return linked.ID()
+		}
+		fmt.Println(doc.path, "is a hard link to", linked.Path(), "indexing separately")
+		return i.docs.NewID()
+	}
+
+	// The file may have been removed and recreated by a rename
+	if id, ok := i.docs.InodeID(doc.device, doc.inode); ok {
+		if _, used := i.docs.FetchID(id); !used {
+			return id
+		}
+	}
+	return i.docs.NewID()
+}
+
+// renamed returns true if the document's file no longer exists, as it has been moved to the path
+func (i *Index) renamed(doc *doclist.Document, path string) bool {
+	if doc.Path() == path {
+		return false
+	}
+	_, err := os.Lstat(doc.Path())
+	return os.IsNotExist(err)
+}
+
+func hashFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
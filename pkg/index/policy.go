@@ -0,0 +1,185 @@
+package index
+
+import (
+	"flash/tools/ignore"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Policy restricts which files under a root directory are indexed. The zero value of
+// each setting leaves it unrestricted, except that hidden files are skipped unless
+// Hidden is set. Extensions are compared without case or a leading dot
+type Policy struct {
+	Dir         string   `json:"dir" mapstructure:"dir"`
+	MaxSize     int64    `json:"max_size,omitempty" mapstructure:"max_size"`
+	Extensions  []string `json:"ext,omitempty" mapstructure:"ext"`
+	Exclude     []string `json:"exclude_ext,omitempty" mapstructure:"exclude_ext"`
+	MaxDepth    int      `json:"max_depth,omitempty" mapstructure:"max_depth"`
+	Hidden      bool     `json:"hidden,omitempty" mapstructure:"hidden"`
+	FollowLinks bool     `json:"follow_links,omitempty" mapstructure:"follow_links"`
+}
+
+// ConfiguredPolicies returns the policies of the roots set in the config
+func ConfiguredPolicies() []Policy {
+	var policies []Policy
+	if err := viper.UnmarshalKey("roots", &policies); err != nil {
+		return nil
+	}
+	return policies
+}
+
+// AllowDir returns true if the files in the directory may be indexed
+func (p Policy) AllowDir(path string) bool {
+	elements, ok := p.elements(path)
+	return ok && (p.MaxDepth <= 0 || len(elements) < p.MaxDepth)
+}
+
+// AllowFile returns true if the file may be indexed
+func (p Policy) AllowFile(path string, info os.FileInfo) bool {
+	elements, ok := p.elements(path)
+	if !ok || !info.Mode().IsRegular() {
+		return false
+	}
+	if p.MaxDepth > 0 && len(elements) > p.MaxDepth {
+		return false
+	}
+	if p.MaxSize > 0 && info.Size() > p.MaxSize {
+		return false
+	}
+
+	ext := normalizeExt(filepath.Ext(path))
+	if len(p.Extensions) > 0 && !containsExt(p.Extensions, ext) {
+		return false
+	}
+	return !containsExt(p.Exclude, ext)
+}
+
+// elements returns the elements of the path below the policy's directory, or just its
+// name if the policy has no directory. False is returned if any of them are hidden
+func (p Policy) elements(path string) ([]string, bool) {
+	elements := []string{filepath.Base(path)}
+	if p.Dir != "" {
+		rel, err := filepath.Rel(p.Dir, path)
+		if err != nil || rel == "." {
+			return nil, err == nil
+		}
+		elements = strings.Split(rel, string(filepath.Separator))
+	}
+
+	for _, e := range elements {
+		if !p.Hidden && len(e) > 0 && e[0:1] == "." {
+			return elements, false
+		}
+	}
+	return elements, true
+}
+
+func (p *Policy) normalize() {
+	p.Dir = filepath.Clean(p.Dir)
+	for i := range p.Extensions {
+		p.Extensions[i] = normalizeExt(p.Extensions[i])
+	}
+	for i := range p.Exclude {
+		p.Exclude[i] = normalizeExt(p.Exclude[i])
+	}
+}
+
+func normalizeExt(ext string) string {
+	return strings.ToLower(strings.TrimPrefix(ext, "."))
+}
+
+func containsExt(exts []string, ext string) bool {
+	for _, e := range exts {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPolicy sets the policy of the policy's directory, replacing any previous policy
+func (i *Index) SetPolicy(p Policy) {
+	p.normalize()
+	i.policies[p.Dir] = p
+}
+
+// RemovePolicy removes the policy of the directory, so that its files are indexed by default
+func (i *Index) RemovePolicy(dir string) {
+	delete(i.policies, filepath.Clean(dir))
+}
+
+// ResetPolicies removes the policies of every directory
+func (i *Index) ResetPolicies() {
+	i.policies = make(map[string]Policy)
+}
+
+// Policies returns the policy of each directory which has one
+func (i *Index) Policies() []Policy {
+	policies := make([]Policy, 0, len(i.policies))
+	for _, p := range i.policies {
+		policies = append(policies, p)
+	}
+	sort.Slice(policies, func(a, b int) bool {
+		return policies[a].Dir < policies[b].Dir
+	})
+	return policies
+}
+
+// Policy returns the policy of the innermost root or directory with a policy which
+// contains the path. Roots without a policy of their own use the default policy
+func (i *Index) Policy(path string) Policy {
+	var policy Policy
+	for dir, p := range i.policies {
+		if underRoot(path, []string{dir}) && len(dir) > len(policy.Dir) {
+			policy = p
+		}
+	}
+	for _, dir := range i.roots {
+		if dir = filepath.Clean(dir); underRoot(path, []string{dir}) && len(dir) > len(policy.Dir) {
+			policy = Policy{Dir: dir}
+		}
+	}
+	return policy
+}
+
+// Root returns the directory which the policy applies to, or the directory containing
+// the path if it's outside of every root. Ignore files are read from there down
+func (p Policy) Root(path string) string {
+	if p.Dir != "" {
+		return p.Dir
+	}
+	return filepath.Dir(filepath.Clean(path))
+}
+
+// Prune removes the documents under the directory which are now excluded by their
+// policies or ignore files, returning the number removed
+func (i *Index) Prune(dir string) int {
+	removed := 0
+	trees := make(map[string]*ignore.Tree)
+	for path := range i.docs.GetSubtree(dir) {
+		if info, err := os.Stat(path); err == nil && i.excluded(path, info, trees) {
+			i.Delete(path)
+			removed++
+		}
+	}
+	return removed
+}
+
+// excluded returns true if the file is excluded by its policy or an ignore file. The
+// ignore files of each root are read into trees as they're needed
+func (i *Index) excluded(path string, info os.FileInfo, trees map[string]*ignore.Tree) bool {
+	policy := i.Policy(path)
+	if !policy.AllowFile(path, info) {
+		return true
+	}
+
+	root := policy.Root(path)
+	if trees[root] == nil {
+		trees[root] = ignore.NewTree(root)
+	}
+	return trees[root].Ignored(path, false)
+}
@@ -3,7 +3,9 @@ package postinglist
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"flash/tools/readers"
+	"fmt"
 	"sort"
 )
 
@@ -14,6 +16,12 @@ type List struct {
 	sorted   bool
 }
 
+// Approximate memory used by a list, and by each of its postings
+const (
+	listSize    = 96
+	postingSize = 48
+)
+
 // Posting type
 type Posting struct {
 	docID     uint64
@@ -34,9 +42,10 @@ func Decode(buf *bytes.Buffer, invalidDocs map[uint64]bool) (*List, bool) {
 
 	numDocs := readers.ReadUint32(buf)
 	l.docs = make([]uint64, 0, numDocs)
+	var id uint64
 	for buf.Len() > 0 {
-		id := readers.ReadUint64(buf)
-		frequency := readers.ReadUint32(buf)
+		id += readers.ReadUvarint(buf)
+		frequency := uint32(readers.ReadUvarint(buf))
 
 		if _, invalid := invalidDocs[id]; !invalid {
 			l.Add(id, frequency)
@@ -45,6 +54,44 @@ func Decode(buf *bytes.Buffer, invalidDocs map[uint64]bool) (*List, bool) {
 	return l, len(l.docs) != 0
 }
 
+// Validate checks that the buffer contains a complete posting list
+func Validate(data []byte) error {
+	if len(data) < 4 {
+		return errors.New("posting list is truncated")
+	}
+	numDocs := binary.LittleEndian.Uint32(data)
+
+	var count uint32
+	for i := 4; i < len(data); count++ {
+		for field := 0; field < 2; field++ {
+			_, n := binary.Uvarint(data[i:])
+			if n <= 0 {
+				return errors.New("posting list is truncated")
+			}
+			i += n
+		}
+	}
+
+	if count != numDocs {
+		return fmt.Errorf("posting list has %d documents, expected %d", count, numDocs)
+	}
+	return nil
+}
+
+// DecodeLegacy creates a posting list from a buffer written before posting lists
+// were compressed, where each posting is a fixed width doc id and frequency
+func DecodeLegacy(buf *bytes.Buffer) *List {
+	l := NewList()
+
+	readers.ReadUint32(buf)
+	for buf.Len() > 0 {
+		id := readers.ReadUint64(buf)
+		frequency := readers.ReadUint32(buf)
+		l.Add(id, frequency)
+	}
+	return l
+}
+
 // Add adds the offsets to the entry for the given doc
 func (l *List) Add(docID uint64, occurences uint32) {
 	var p *Posting
@@ -60,6 +107,13 @@ func (l *List) Add(docID uint64, occurences uint32) {
 	p.addOccurences(occurences)
 }
 
+// Merge adds every posting in the other list to this list
+func (l *List) Merge(other *List) {
+	for _, id := range other.docs {
+		l.Add(id, other.postings[id].frequency)
+	}
+}
+
 // Delete removes the given doc from the postinglist
 func (l *List) Delete(docID uint64) {
 	if _, ok := l.postings[docID]; ok {
@@ -84,6 +138,19 @@ func (p *Posting) addOccurences(occurences uint32) {
 	p.frequency += occurences
 }
 
+// Frequency returns the number of occurences in the given doc
+func (l *List) Frequency(docID uint64) uint32 {
+	if p, ok := l.postings[docID]; ok {
+		return p.frequency
+	}
+	return 0
+}
+
+// MemoryUsage returns the approximate number of bytes used by the list
+func (l *List) MemoryUsage() int64 {
+	return listSize + int64(len(l.postings))*postingSize
+}
+
 // GetDocs returns a list of documents in the postinglist
 func (l *List) GetDocs() []uint64 {
 	if !l.sorted {
@@ -94,15 +161,22 @@ func (l *List) GetDocs() []uint64 {
 	return l.docs
 }
 
-// Bytes gives the posting list as a byte buffer
+// Bytes gives the posting list as a byte buffer. Each posting is stored as the
+// difference from the previous doc id followed by the frequency, both as uvarints
 func (l *List) Bytes() *bytes.Buffer {
 	buf := new(bytes.Buffer)
 	docs := l.GetDocs()
 	binary.Write(buf, binary.LittleEndian, uint32(len(docs)))
+
+	scratch := make([]byte, binary.MaxVarintLen64)
+	var prev uint64
 	for _, id := range docs {
 		p := l.postings[id]
-		binary.Write(buf, binary.LittleEndian, p.docID)
-		binary.Write(buf, binary.LittleEndian, p.frequency)
+		n := binary.PutUvarint(scratch, p.docID-prev)
+		buf.Write(scratch[:n])
+		n = binary.PutUvarint(scratch, uint64(p.frequency))
+		buf.Write(scratch[:n])
+		prev = p.docID
 	}
 	return buf
 }
@@ -0,0 +1,126 @@
+package postinglist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flash/tools/readers"
+	"math/rand"
+	"testing"
+)
+
+const benchmarkPostings = 10000
+
+func randomList(n int, gap int) *List {
+	r := rand.New(rand.NewSource(1))
+	l := NewList()
+	var id uint64
+	for i := 0; i < n; i++ {
+		id += uint64(r.Intn(gap) + 1)
+		l.Add(id, uint32(r.Intn(20)+1))
+	}
+	return l
+}
+
+// fixedBytes encodes the list in the previous format, using 8 bytes for each
+// doc id and 4 bytes for each frequency
+func fixedBytes(l *List) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	docs := l.GetDocs()
+	binary.Write(buf, binary.LittleEndian, uint32(len(docs)))
+	for _, id := range docs {
+		binary.Write(buf, binary.LittleEndian, id)
+		binary.Write(buf, binary.LittleEndian, l.postings[id].frequency)
+	}
+	return buf
+}
+
+func readFixed(buf *bytes.Buffer) {
+	readers.ReadUint32(buf)
+	for buf.Len() > 0 {
+		readers.ReadUint64(buf)
+		readers.ReadUint32(buf)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	l := randomList(1000, 1<<20)
+	decoded, ok := Decode(l.Bytes(), map[uint64]bool{})
+	if !ok || len(decoded.GetDocs()) != 1000 {
+		t.FailNow()
+	}
+
+	for _, id := range l.GetDocs() {
+		if p, ok := decoded.postings[id]; !ok || p.frequency != l.postings[id].frequency {
+			t.Fatal(id)
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	l := NewList()
+	l.Add(300, 2)
+	l.Add(5, 1)
+	l.Add(1<<40, 7)
+
+	r := NewReader(l.Bytes(), map[uint64]bool{300: true})
+	expected := []uint64{5, 1 << 40}
+	frequencies := []uint32{1, 7}
+
+	i := 0
+	for r.Read() {
+		id, freq := r.Data()
+		if id != expected[i] || freq != frequencies[i] {
+			t.Error(id, freq)
+		}
+		i++
+	}
+
+	if i != 2 || r.NumDocs() != 3 {
+		t.Fail()
+	}
+}
+
+func TestSize(t *testing.T) {
+	l := randomList(benchmarkPostings, 64)
+	if l.Bytes().Len() >= fixedBytes(l).Len()/3 {
+		t.Error(l.Bytes().Len(), fixedBytes(l).Len())
+	}
+}
+
+func benchmarkEncode(b *testing.B, encode func(*List) *bytes.Buffer) {
+	l := randomList(benchmarkPostings, 64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		encode(l)
+	}
+	b.ReportMetric(float64(encode(l).Len())/benchmarkPostings, "bytes/posting")
+}
+
+func benchmarkDecode(b *testing.B, encode func(*List) *bytes.Buffer, read func(*bytes.Buffer)) {
+	data := encode(randomList(benchmarkPostings, 64)).Bytes()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		read(bytes.NewBuffer(data))
+	}
+}
+
+func BenchmarkEncodeVarint(b *testing.B) {
+	benchmarkEncode(b, (*List).Bytes)
+}
+
+func BenchmarkEncodeFixed(b *testing.B) {
+	benchmarkEncode(b, fixedBytes)
+}
+
+func BenchmarkDecodeVarint(b *testing.B) {
+	benchmarkDecode(b, (*List).Bytes, func(buf *bytes.Buffer) {
+		r := NewReader(buf, nil)
+		for r.Read() {
+		}
+	})
+}
+
+func BenchmarkDecodeFixed(b *testing.B) {
+	benchmarkDecode(b, fixedBytes, readFixed)
+}
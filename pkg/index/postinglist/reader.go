@@ -29,8 +29,8 @@ func (r *Reader) Read() (ok bool) {
 		return false
 	}
 
-	r.id = readers.ReadUint64(r.buffer)
-	r.frequency = readers.ReadUint32(r.buffer)
+	r.id += readers.ReadUvarint(r.buffer)
+	r.frequency = uint32(readers.ReadUvarint(r.buffer))
 
 	if _, ok := r.invalidDocs[r.id]; ok {
 		return r.Read()
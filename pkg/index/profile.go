@@ -0,0 +1,202 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/spf13/viper"
+)
+
+// DefaultProfile is the name of the index configured by configs written before there
+// were named indexes
+const DefaultProfile = "default"
+
+// Names are lowercase, as viper doesn't preserve the case of keys
+var profileName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Profile is the configuration of a named index. The current index is configured by
+// the top level settings of the config, so that commands which don't know about named
+// indexes use it, and the others are kept under indexes
+type Profile struct {
+	Name      string   `json:"-" mapstructure:"-"`
+	Path      string   `json:"indexpath" mapstructure:"indexpath"`
+	Dirs      []string `json:"dirs" mapstructure:"dirs"`
+	Blacklist []string `json:"blacklist" mapstructure:"blacklist"`
+	Roots     []Policy `json:"roots,omitempty" mapstructure:"roots"`
+}
+
+// CurrentProfile returns the configuration of the current index
+func CurrentProfile() Profile {
+	name := viper.GetString("current_index")
+	if name == "" {
+		name = DefaultProfile
+	}
+	return Profile{
+		Name:      name,
+		Path:      viper.GetString("indexpath"),
+		Dirs:      viper.GetStringSlice("dirs"),
+		Blacklist: viper.GetStringSlice("blacklist"),
+		Roots:     ConfiguredPolicies(),
+	}
+}
+
+// Profiles returns the configuration of every index, sorted by name
+func Profiles() []Profile {
+	profiles := []Profile{CurrentProfile()}
+	for _, p := range otherProfiles() {
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(a, b int) bool {
+		return profiles[a].Name < profiles[b].Name
+	})
+	return profiles
+}
+
+// GetProfile returns the configuration of the named index
+func GetProfile(name string) (Profile, bool) {
+	if current := CurrentProfile(); current.Name == name {
+		return current, true
+	}
+	p, ok := otherProfiles()[name]
+	return p, ok
+}
+
+// CreateProfile adds an empty index to the config. It's stored at the given path, or
+// under flashhome if the path is empty
+func CreateProfile(name, path string) (Profile, error) {
+	if !profileName.MatchString(name) {
+		return Profile{}, fmt.Errorf("invalid index name %q, use lowercase letters, digits, - and _", name)
+	}
+	if path == "" {
+		path = filepath.Join(viper.GetString("flashhome"), "indexes", name)
+	}
+	path = filepath.Clean(path)
+
+	for _, p := range Profiles() {
+		if p.Name == name {
+			return Profile{}, fmt.Errorf("index %v already exists", name)
+		}
+		if filepath.Clean(p.Path) == path {
+			return Profile{}, fmt.Errorf("%v is already used by index %v", path, p.Name)
+		}
+	}
+
+	p := Profile{Name: name, Path: path, Dirs: []string{}, Blacklist: []string{}}
+	SaveProfile(p)
+	return p, nil
+}
+
+// SaveProfile writes the configuration of an index to the config
+func SaveProfile(p Profile) {
+	if p.Name == CurrentProfile().Name {
+		viper.Set("indexpath", p.Path)
+		viper.Set("dirs", p.Dirs)
+		viper.Set("blacklist", p.Blacklist)
+		viper.Set("roots", p.Roots)
+		return
+	}
+
+	profiles := otherProfiles()
+	profiles[p.Name] = p
+	viper.Set("indexes", profiles)
+}
+
+// UseProfile makes the named index the current one, moving the configuration of the
+// previous index under indexes
+func UseProfile(name string) error {
+	current := CurrentProfile()
+	if name == current.Name {
+		return nil
+	}
+	profiles := otherProfiles()
+	p, ok := profiles[name]
+	if !ok {
+		return errors.New("no index named " + name)
+	}
+
+	delete(profiles, name)
+	profiles[current.Name] = current
+	viper.Set("indexes", profiles)
+	viper.Set("current_index", name)
+	SaveProfile(p)
+	return nil
+}
+
+// otherProfiles returns the configuration of each index other than the current one
+func otherProfiles() map[string]Profile {
+	profiles := make(map[string]Profile)
+	if err := viper.UnmarshalKey("indexes", &profiles); err != nil {
+		return make(map[string]Profile)
+	}
+	for name, p := range profiles {
+		p.Name = name
+		profiles[name] = p
+	}
+	return profiles
+}
+
+// Name returns the name of the index's profile
+func (i *Index) Name() string {
+	return i.name
+}
+
+// Profile returns the configuration of the index
+func (i *Index) Profile() Profile {
+	return Profile{
+		Name:      i.name,
+		Path:      i.dir,
+		Dirs:      i.Roots(),
+		Blacklist: i.GetBlacklist(),
+		Roots:     i.Policies(),
+	}
+}
+
+// Roots returns the directories added to the index
+func (i *Index) Roots() []string {
+	return append([]string{}, i.roots...)
+}
+
+// AddRoot adds a directory to the roots of the index, without indexing it
+func (i *Index) AddRoot(dir string) error {
+	for _, root := range i.roots {
+		if root == dir {
+			return errors.New("Directory already in index")
+		}
+	}
+	i.roots = append(i.roots, dir)
+	return nil
+}
+
+// RemoveRoot removes a directory from the roots of the index, without deleting its documents
+func (i *Index) RemoveRoot(dir string) {
+	for n, root := range i.roots {
+		if root == dir {
+			i.roots = append(i.roots[:n], i.roots[n+1:]...)
+			return
+		}
+	}
+}
+
+// ResetRoots removes every root of the index
+func (i *Index) ResetRoots() {
+	i.roots = []string{}
+}
+
+// Contains returns true if the path is under one of the roots of the index
+func (i *Index) Contains(path string) bool {
+	return underRoot(filepath.Clean(path), i.roots)
+}
+
+// configure applies the roots, blacklist and policies of the profile to the index
+func (i *Index) configure(p Profile) {
+	i.name = p.Name
+	i.roots = append([]string{}, p.Dirs...)
+	i.blacklist.Add(p.Blacklist...)
+	i.ResetPolicies()
+	for _, policy := range p.Roots {
+		i.SetPolicy(policy)
+	}
+}
@@ -0,0 +1,121 @@
+package index
+
+import (
+	"flash/tools/ignore"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ScanProgress reports the progress of a reconciliation scan, which finds the
+// changes made to the indexed directories while the daemon wasn't running
+type ScanProgress struct {
+	Running  bool
+	Finished bool
+	Root     string
+	Checked  int
+	Indexed  int
+	Skipped  int
+	Deleted  int
+}
+
+type scanState struct {
+	sync.Mutex
+	progress ScanProgress
+}
+
+// ScanProgress returns the progress of the current or most recent reconciliation scan
+func (i *Index) ScanProgress() ScanProgress {
+	i.scan.Lock()
+	defer i.scan.Unlock()
+	return i.scan.progress
+}
+
+func (i *Index) updateScan(update func(p *ScanProgress)) {
+	i.scan.Lock()
+	update(&i.scan.progress)
+	i.scan.Unlock()
+}
+
+// Reconcile brings the index up to date with the given roots. Each root is walked
+// to add new files and reindex changed ones, then documents whose files have been
+// removed, blacklisted, excluded by a policy or ignore file, or are no longer under
+// any of the roots are deleted. Files are added first so that those which were renamed
+// keep their postings. Roots which were requested to be reindexed have their documents
+// removed before they're walked
+func (i *Index) Reconcile(roots []string, lock *sync.RWMutex) {
+	i.updateScan(func(p *ScanProgress) {
+		*p = ScanProgress{Running: true}
+	})
+
+	forced := i.pendingReindex()
+	for _, root := range roots {
+		i.updateScan(func(p *ScanProgress) { p.Root = root })
+		if forced[root] {
+			fmt.Println("Reindexing", root)
+			lock.Lock()
+			i.reindex(root)
+			lock.Unlock()
+		}
+		i.add(root, lock, func(indexed bool) {
+			i.updateScan(func(p *ScanProgress) {
+				p.Checked++
+				if indexed {
+					p.Indexed++
+				} else {
+					p.Skipped++
+				}
+			})
+		})
+	}
+
+	for _, path := range i.stale(roots, lock) {
+		lock.Lock()
+		i.Delete(path)
+		lock.Unlock()
+		i.updateScan(func(p *ScanProgress) { p.Deleted++ })
+	}
+
+	if len(forced) > 0 {
+		os.Remove(getReindexPath(i.dir))
+	}
+
+	i.updateScan(func(p *ScanProgress) {
+		p.Running = false
+		p.Finished = true
+		p.Root = ""
+		fmt.Printf("Reconciled index: %d indexed, %d unchanged, %d deleted\n", p.Indexed, p.Skipped, p.Deleted)
+	})
+}
+
+// stale returns the paths of the documents which should no longer be in the index
+func (i *Index) stale(roots []string, lock *sync.RWMutex) []string {
+	var paths []string
+	trees := make(map[string]*ignore.Tree)
+	lock.RLock()
+	for _, doc := range i.docs.Documents() {
+		if i.blacklist.Contains(doc.Path()) || !underRoot(doc.Path(), roots) {
+			paths = append(paths, doc.Path())
+			continue
+		}
+		if info, err := os.Stat(doc.Path()); err != nil || i.excluded(doc.Path(), info, trees) {
+			paths = append(paths, doc.Path())
+		}
+	}
+	lock.RUnlock()
+	return paths
+}
+
+// underRoot returns true if the path is one of the roots, or is contained in one
+func underRoot(path string, roots []string) bool {
+	for _, root := range roots {
+		root = filepath.Clean(root)
+		dir := strings.TrimSuffix(root, string(filepath.Separator)) + string(filepath.Separator)
+		if path == root || strings.HasPrefix(path, dir) {
+			return true
+		}
+	}
+	return false
+}
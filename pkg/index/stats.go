@@ -0,0 +1,122 @@
+package index
+
+import (
+	"container/heap"
+	"flash/pkg/index/partition"
+	"flash/pkg/index/postinglist"
+	"mime"
+	"path/filepath"
+	"sort"
+)
+
+// Stats describes the contents of the index
+type Stats struct {
+	NumDocs    uint32
+	AvgLength  float64
+	Collectors []partition.CollectorStats
+	Memory     int64
+	Budget     int64
+	Vocabulary int
+	TopTerms   []TermFrequency
+	FileTypes  []FileTypeStats
+}
+
+// TermFrequency is the number of documents which contain a term
+type TermFrequency struct {
+	Term    string
+	DocFreq int
+}
+
+// FileTypeStats is the number of documents of a type, and their total size
+type FileTypeStats struct {
+	Type  string
+	Docs  int
+	Bytes int64
+}
+
+// Stats scans the index, returning the given number of terms with the highest document frequency
+func (i *Index) Stats(numTerms int) *Stats {
+	s := &Stats{
+		NumDocs:    i.docs.NumDocs(),
+		AvgLength:  i.docs.AvgLength(),
+		Collectors: append([]partition.CollectorStats{i.collector.Stats()}, i.docs.Stats()...),
+		Memory:     i.budget.Used(),
+		Budget:     i.budget.Limit(),
+	}
+
+	top := &frequencyHeap{}
+	i.collector.Scan(func(term string, entries []partition.Entry) {
+		df := 0
+		for _, e := range entries {
+			if l, ok := e.(*postinglist.List); ok {
+				df += len(l.GetDocs())
+			}
+		}
+		if df == 0 {
+			return
+		}
+
+		s.Vocabulary++
+		if top.Len() < numTerms {
+			heap.Push(top, TermFrequency{term, df})
+		} else if top.Len() > 0 && (*top)[0].DocFreq < df {
+			(*top)[0] = TermFrequency{term, df}
+			heap.Fix(top, 0)
+		}
+	})
+
+	s.TopTerms = *top
+	sort.Slice(s.TopTerms, func(a, b int) bool {
+		if s.TopTerms[a].DocFreq == s.TopTerms[b].DocFreq {
+			return s.TopTerms[a].Term < s.TopTerms[b].Term
+		}
+		return s.TopTerms[a].DocFreq > s.TopTerms[b].DocFreq
+	})
+
+	types := make(map[string]*FileTypeStats)
+	for _, doc := range i.docs.Documents() {
+		t := fileType(doc.Metadata().MIME, doc.Path())
+		if _, ok := types[t]; !ok {
+			types[t] = &FileTypeStats{Type: t}
+		}
+		types[t].Docs++
+		types[t].Bytes += doc.Size()
+	}
+	for _, t := range types {
+		s.FileTypes = append(s.FileTypes, *t)
+	}
+	sort.Slice(s.FileTypes, func(a, b int) bool { return s.FileTypes[a].Bytes > s.FileTypes[b].Bytes })
+
+	return s
+}
+
+// fileType returns the MIME type of a document, falling back to its extension
+// for documents which were indexed before the MIME type was stored
+func fileType(mimeType, path string) string {
+	if mimeType != "" {
+		return mimeType
+	}
+	if t, _, err := mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(path))); err == nil {
+		return t
+	}
+	return "unknown"
+}
+
+// frequencyHeap is a min heap of term frequencies, used to keep the most frequent terms
+type frequencyHeap []TermFrequency
+
+func (h frequencyHeap) Len() int           { return len(h) }
+func (h frequencyHeap) Less(i, j int) bool { return h[i].DocFreq < h[j].DocFreq }
+func (h frequencyHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *frequencyHeap) Push(x interface{}) {
+	*h = append(*h, x.(TermFrequency))
+}
+
+func (h *frequencyHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
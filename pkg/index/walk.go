@@ -0,0 +1,154 @@
+package index
+
+import (
+	"flash/tools/ignore"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+)
+
+// fileID identifies a file by its device and inode, whatever path it's reached by
+type fileID struct {
+	device uint64
+	inode  uint64
+}
+
+// walker walks the directories allowed by a policy. Symbolic links are only followed
+// if the policy allows it, once the rest of the tree has been walked, so that files
+// are found under their own paths before those of any links to them
+type walker struct {
+	policy  Policy
+	tree    *ignore.Tree
+	fn      filepath.WalkFunc
+	visited map[fileID]bool
+	links   []string
+	linked  bool
+}
+
+// Walk calls fn for the directory and each directory and file under it, like
+// filepath.Walk. Directories excluded by the policy and paths excluded by ignore files
+// are skipped. If the policy follows links, each directory is only visited once, however
+// many links lead to it, which also stops links from forming cycles. Files reached through
+// a link are skipped if they were already visited, but hard links are visited under each
+// of their own paths
+func (p Policy) Walk(dir string, fn filepath.WalkFunc) error {
+	w := &walker{
+		policy:  p,
+		tree:    ignore.NewTree(p.Root(dir)),
+		fn:      fn,
+		visited: make(map[fileID]bool),
+	}
+
+	// A root which is itself a link is always followed
+	info, err := os.Lstat(dir)
+	if err == nil && info.Mode()&os.ModeSymlink != 0 && (p.FollowLinks || filepath.Clean(dir) == p.Dir) {
+		info, err = os.Stat(dir)
+	}
+	if err != nil {
+		return fn(dir, nil, err)
+	}
+
+	if err := w.walk(dir, info); err != nil {
+		return err
+	}
+	w.linked = true
+	for len(w.links) > 0 {
+		link := w.links[0]
+		w.links = w.links[1:]
+		if info, err := os.Stat(link); err == nil {
+			if err := w.walk(link, info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *walker) walk(path string, info os.FileInfo) error {
+	if info.Mode()&os.ModeSymlink != 0 {
+		if w.policy.FollowLinks {
+			w.links = append(w.links, path)
+		}
+		return nil
+	}
+	if w.tree.Ignored(path, info.IsDir()) {
+		return nil
+	}
+
+	if !info.IsDir() {
+		if w.policy.FollowLinks && !w.visit(info) && w.linked {
+			return nil
+		}
+		return skip(w.fn(path, info, nil))
+	}
+
+	if !w.policy.AllowDir(path) || !w.visit(info) {
+		return nil
+	}
+	if err := w.fn(path, info, nil); err != nil {
+		return skip(err)
+	}
+
+	names, err := readDirNames(path)
+	if err != nil {
+		return skip(w.fn(path, info, err))
+	}
+	for _, name := range names {
+		child := filepath.Join(path, name)
+		childInfo, err := os.Lstat(child)
+		if err != nil {
+			err = w.fn(child, nil, err)
+		} else {
+			err = w.walk(child, childInfo)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visit returns false if the file has already been visited
+func (w *walker) visit(info os.FileInfo) bool {
+	sys, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return true
+	}
+	id := fileID{uint64(sys.Dev), sys.Ino}
+	if w.visited[id] {
+		return false
+	}
+	w.visited[id] = true
+	return true
+}
+
+// skip treats filepath.SkipDir as skipping the current file or directory
+func skip(err error) error {
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func readDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	sort.Strings(names)
+	return names, err
+}
+
+// samePath returns true if both paths lead to the same path once symbolic links are resolved.
+// Unlike hard links, which have their own paths, these are the same file
+func samePath(a, b string) bool {
+	realA, err := filepath.EvalSymlinks(a)
+	if err != nil {
+		return false
+	}
+	realB, err := filepath.EvalSymlinks(b)
+	return err == nil && realA == realB
+}
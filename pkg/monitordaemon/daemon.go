@@ -1,7 +1,10 @@
 package monitordaemon
 
 import (
+	"errors"
 	"flash/pkg/index"
+	"flash/pkg/index/partition"
+	"flash/tools/ignore"
 	"flash/tools/tika"
 	"fmt"
 	"log"
@@ -10,8 +13,11 @@ import (
 	"net/rpc"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
@@ -20,14 +26,23 @@ import (
 
 const port = ":9977"
 
-// MonitorDaemon has embedded daemon
+// The index is compacted in the background once it hasn't changed for compactIdle
+const (
+	compactInterval = 10 * time.Minute
+	compactIdle     = 5 * time.Minute
+)
+
+// MonitorDaemon has embedded daemon. It serves every index in the config, sharing a
+// single watcher, lock and memory budget between them. Requests which manage an index
+// apply to the current one
 type MonitorDaemon struct {
 	daemon     daemon.Daemon
 	watcher    *watcher
 	index      *index.Index
+	indexes    map[string]*index.Index
+	budget     *partition.Budget
 	lock       *sync.RWMutex
 	tikaServer *tika.Server
-	dirs       []string
 }
 
 // Init initializes and returns the monitor
@@ -67,31 +82,131 @@ func (d *MonitorDaemon) Stop() (string, error) {
 
 // Run starts the services which the daemon controls
 func (d *MonitorDaemon) Run() {
-	d.index = index.Load(viper.GetString("indexpath"))
+	d.indexes = make(map[string]*index.Index)
+	d.budget = partition.NewBudget(viper.GetInt64("memory_budget"))
+	for _, p := range index.Profiles() {
+		// An index which can't be opened, such as one which must be migrated, is
+		// skipped so that the others are still served
+		if _, err := d.open(p); err != nil {
+			log.Printf("Skipping index %v: %v\nRun 'flash index migrate --index %v' to upgrade it", p.Name, err, p.Name)
+		}
+	}
+	current := index.CurrentProfile().Name
+	if d.index = d.indexes[current]; d.index == nil {
+		log.Fatalf("The current index %v can't be opened", current)
+	}
 
 	d.watcher = newWatcher()
 
 	d.tikaServer = tika.GetServer()
 	d.tikaServer.StartServer()
 
-	d.dirs = viper.GetStringSlice("dirs")
+	for _, i := range d.indexes {
+		for _, dir := range i.Roots() {
+			d.watcher.addDir(dir, i.Policy(dir))
+		}
 
-	for _, dir := range d.dirs {
-		d.watcher.addDir(dir)
+		// Pick up any changes made while the daemon wasn't running
+		go i.Reconcile(i.Roots(), d.lock)
 	}
 
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt, os.Kill, syscall.SIGTERM)
 	go d.watch()
 	go d.handleRequests()
+	go d.compactWhenIdle()
 	<-interrupt
 	d.lock.Lock()
-	d.index.ClearMemory()
+	for _, i := range d.indexes {
+		i.Close()
+	}
 	d.tikaServer.StopServer()
 	viper.WriteConfig()
 	d.lock.Unlock()
 }
 
+// open loads the index of the profile and starts serving it
+func (d *MonitorDaemon) open(p index.Profile) (*index.Index, error) {
+	i, err := index.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	i.SetBudget(d.budget)
+	d.indexes[p.Name] = i
+	return i, nil
+}
+
+// named returns the index with the given name, or the current index if the name is empty
+func (d *MonitorDaemon) named(name string) (*index.Index, error) {
+	if name == "" {
+		return d.index, nil
+	}
+	i, ok := d.indexes[name]
+	if !ok {
+		return nil, errors.New("no index named " + name)
+	}
+	return i, nil
+}
+
+// containing returns the indexes with a root which contains the path
+func (d *MonitorDaemon) containing(path string) []*index.Index {
+	var indexes []*index.Index
+	for _, i := range d.indexes {
+		if i.Contains(path) {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// rewatch watches the directories of every index which are under the directory or
+// contain it. A directory may be in several indexes, so this restores the watches of
+// the others after one of them stops watching it
+func (d *MonitorDaemon) rewatch(dir string) {
+	for _, i := range d.indexes {
+		if i.Contains(dir) {
+			d.watcher.addDir(dir, i.Policy(dir))
+			continue
+		}
+		for _, root := range i.Roots() {
+			if within(root, dir) {
+				d.watcher.addDir(root, i.Policy(root))
+			}
+		}
+	}
+}
+
+// within returns true if the path is the directory or under it
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// isIgnoreFile returns true if the file lists paths to be ignored
+func isIgnoreFile(path string) bool {
+	for _, name := range ignore.Files {
+		if filepath.Base(path) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// rescan applies a change to the ignore files of the directory, removing the files
+// which are now ignored and adding and watching those which no longer are
+func (d *MonitorDaemon) rescan(dir string) {
+	d.lock.Lock()
+	indexes := d.containing(dir)
+	for _, i := range indexes {
+		i.Prune(dir)
+		d.watcher.addDir(dir, i.Policy(dir))
+	}
+	d.lock.Unlock()
+	for _, i := range indexes {
+		go i.Add(dir, d.lock)
+	}
+}
+
 // watch watches for file changes in the added files
 func (d *MonitorDaemon) watch() {
 	for {
@@ -101,21 +216,37 @@ func (d *MonitorDaemon) watch() {
 				return
 			}
 			log.Println(event)
+			if isIgnoreFile(event.Name) {
+				d.rescan(filepath.Dir(event.Name))
+				continue
+			}
+
+			// The change applies to every index with a root which contains it
+			d.lock.RLock()
+			indexes := d.containing(event.Name)
+			d.lock.RUnlock()
+
 			switch event.Op {
 			case fsnotify.Create:
 				stat, err := os.Stat(event.Name)
 				if err == nil && stat.IsDir() {
 					d.lock.Lock()
-					d.watcher.addDir(event.Name)
+					for _, i := range indexes {
+						d.watcher.addDir(event.Name, i.Policy(event.Name))
+					}
 					d.lock.Unlock()
 				}
 				fallthrough
 			case fsnotify.Write, fsnotify.Chmod:
-				d.index.Add(event.Name, d.lock)
+				for _, i := range indexes {
+					i.Add(event.Name, d.lock)
+				}
 			case fsnotify.Rename, fsnotify.Remove:
 				if _, err := os.Stat(event.Name); err != nil {
 					d.lock.Lock()
-					d.index.Delete(event.Name)
+					for _, i := range indexes {
+						i.Delete(event.Name)
+					}
 					d.lock.Unlock()
 				}
 			default:
@@ -130,6 +261,30 @@ func (d *MonitorDaemon) watch() {
 	}
 }
 
+// compactWhenIdle removes deleted documents from the partitions of each index while nothing is being indexed
+func (d *MonitorDaemon) compactWhenIdle() {
+	ticker := time.NewTicker(compactInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		d.lock.RLock()
+		indexes := make([]*index.Index, 0, len(d.indexes))
+		for _, i := range d.indexes {
+			indexes = append(indexes, i)
+		}
+		d.lock.RUnlock()
+
+		for _, i := range indexes {
+			if !i.Idle(compactIdle) {
+				continue
+			}
+			if res := i.Compact(d.lock, viper.GetInt("compact_tombstones")); res.Partitions > 0 {
+				fmt.Printf("Compacted %d partitions of %v, reclaiming %d bytes\n", res.Partitions, i.Name(), res.Reclaimed)
+			}
+		}
+	}
+}
+
 func (d *MonitorDaemon) handleRequests() {
 	fmt.Println("Handling")
 	h := &Handler{d}
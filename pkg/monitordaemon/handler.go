@@ -4,7 +4,12 @@ import (
 	"errors"
 	"flash/pkg/index"
 	"flash/pkg/search"
+	"fmt"
 	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -14,10 +19,12 @@ type Handler struct {
 	dmn *MonitorDaemon
 }
 
-// Query is used to communicate search queries
+// Query is used to communicate search queries. The current index is searched unless
+// another is named
 type Query struct {
-	Str string
-	N   int
+	Str   string
+	N     int
+	Index string
 }
 
 // Results is returned from a search
@@ -36,18 +43,67 @@ type DirList struct {
 	Dirs []string
 }
 
+// DocInfo holds the metadata of an indexed document
+type DocInfo struct {
+	Path     string
+	Size     int64
+	Modified time.Time
+	MIME     string
+	Owner    string
+	Title    string
+	Author   string
+	Created  time.Time
+}
+
+// IndexList describes the indexes served by the daemon
+type IndexList struct {
+	Current string
+	Indexes []index.Profile
+}
+
+// ExportRequest names the file to export a snapshot to. The current index is exported
+// unless another is named
+type ExportRequest struct {
+	Path  string
+	Index string
+}
+
+// DocInfoRequest names the document to describe. It's looked up in the current index
+// unless another is named
+type DocInfoRequest struct {
+	Path  string
+	Index string
+}
+
+// StatsRequest asks for statistics about the current index, or the named one, including
+// the given number of most frequent terms
+type StatsRequest struct {
+	NumTerms int
+	Index    string
+}
+
+// Duplicates is a list of duplicate document groups
+type Duplicates struct {
+	Groups []index.DuplicateGroup
+}
+
 // Search searches the index for a query
 func (h *Handler) Search(q *Query, res *Results) error {
-	engine := search.NewEngine(h.dmn.index)
-
 	h.dmn.lock.RLock()
+	defer h.dmn.lock.RUnlock()
+
+	i, err := h.dmn.named(q.Index)
+	if err != nil {
+		return err
+	}
+
+	engine := search.NewEngine(i)
 	results := engine.Search(q.Str, q.N)
 	for _, val := range results {
-		path, _, _ := h.dmn.index.GetDocInfo(val.ID)
+		path, _, _ := i.GetDocInfo(val.ID)
 		res.Paths = append(res.Paths, path)
 		res.Scores = append(res.Scores, val.Score)
 	}
-	h.dmn.lock.RUnlock()
 	return nil
 }
 
@@ -56,21 +112,26 @@ func (h *Handler) Reset(confirmation string, res *bool) error {
 	h.dmn.lock.Lock()
 	defer h.dmn.lock.Unlock()
 
-	path := h.dmn.index.GetPath()
-	err := os.RemoveAll(path)
+	p := h.dmn.index.Profile()
+	h.dmn.index.Close()
+	err := os.RemoveAll(p.Path)
 	if err != nil {
 		return err
 	}
-	h.dmn.index = index.Load(path)
 
-	for _, d := range viper.GetStringSlice("dirs") {
-		h.dmn.watcher.Remove(d)
+	dirs := p.Dirs
+	p.Dirs, p.Blacklist, p.Roots = []string{}, []string{}, []index.Policy{}
+	i, err := h.dmn.open(p)
+	if err != nil {
+		return err
 	}
+	h.dmn.index = i
+	index.SaveProfile(p)
 
-	h.dmn.index.ResetBlacklist()
-
-	viper.Set("dirs", []string{})
-	viper.Set("blacklist", []string{})
+	for _, d := range dirs {
+		h.dmn.watcher.Remove(d)
+		h.dmn.rewatch(d)
+	}
 	return nil
 }
 
@@ -83,17 +144,13 @@ func (h *Handler) Add(dir string, res *bool) error {
 
 	h.dmn.lock.Lock()
 	defer h.dmn.lock.Unlock()
-	dirs := viper.GetStringSlice("dirs")
 
-	for _, d := range dirs {
-		if d == dir {
-			return errors.New("Directory already in index")
-		}
+	if err := h.dmn.index.AddRoot(dir); err != nil {
+		return err
 	}
+	index.SaveProfile(h.dmn.index.Profile())
 
-	viper.Set("dirs", append(dirs, dir))
-
-	h.dmn.watcher.addDir(dir)
+	h.dmn.watcher.addDir(dir, h.dmn.index.Policy(dir))
 	go h.dmn.index.Add(dir, h.dmn.lock)
 
 	return nil
@@ -109,64 +166,290 @@ func (h *Handler) Remove(dir string, res *bool) error {
 	h.dmn.lock.Lock()
 	defer h.dmn.lock.Unlock()
 
-	dirs := viper.GetStringSlice("dirs")
-	for i := range dirs {
-		if dirs[i] == dir {
-			dirs[i] = dirs[len(dirs)-1]
-			dirs[len(dirs)-1] = ""
-			dirs = dirs[:len(dirs)-1]
-			break
-		}
-	}
-	viper.Set("dirs", dirs)
-
+	h.dmn.index.RemoveRoot(dir)
 	h.dmn.watcher.removeDir(dir)
 	h.dmn.index.Delete(dir)
+	h.dmn.index.RemovePolicy(dir)
+	index.SaveProfile(h.dmn.index.Profile())
+
+	// Other indexes may still watch the directory
+	h.dmn.rewatch(dir)
 	return nil
 }
 
-// BlacklistAdd adds a pattern to the blacklist
+// SetPolicy sets the policy of a directory. If the directory has already been added,
+// res is set to true and it's rescanned, removing the files the policy now excludes
+// and adding those it now includes
+func (h *Handler) SetPolicy(policy index.Policy, res *bool) error {
+	if _, err := os.Stat(policy.Dir); err != nil {
+		return err
+	}
+
+	h.dmn.lock.Lock()
+	defer h.dmn.lock.Unlock()
+
+	h.dmn.index.SetPolicy(policy)
+	index.SaveProfile(h.dmn.index.Profile())
+
+	for _, dir := range h.dmn.index.Roots() {
+		if dir == policy.Dir {
+			h.dmn.watcher.removeDir(dir)
+			h.dmn.rewatch(dir)
+			h.dmn.index.Prune(dir)
+			go h.dmn.index.Add(dir, h.dmn.lock)
+			*res = true
+		}
+	}
+	return nil
+}
+
+// BlacklistAdd adds a pattern to the blacklist, removing the documents it matches
 func (h *Handler) BlacklistAdd(pattern string, res *bool) error {
 	h.dmn.lock.Lock()
 	defer h.dmn.lock.Unlock()
 
-	err := h.dmn.index.Blacklist(pattern)
+	removed, err := h.dmn.index.Blacklist(pattern)
 	if err != nil {
 		return err
 	}
+	fmt.Printf("Blacklisted %v, removed %d documents\n", pattern, removed)
 
-	blacklist := viper.GetStringSlice("blacklist")
-	viper.Set("blacklist", append(blacklist, pattern))
+	index.SaveProfile(h.dmn.index.Profile())
 	return nil
 }
 
-// BlacklistRemove adds a pattern to the blacklist
+// BlacklistRemove removes a pattern from the blacklist, adding the files it excluded
 func (h *Handler) BlacklistRemove(pattern string, res *bool) error {
 	h.dmn.lock.Lock()
 	defer h.dmn.lock.Unlock()
 
-	blacklists := viper.GetStringSlice("blacklist")
-	for i := range blacklists {
-		if blacklists[i] == pattern {
-			blacklists[i] = blacklists[len(blacklists)-1]
-			blacklists[len(blacklists)-1] = ""
-			blacklists = blacklists[:len(blacklists)-1]
-			break
-		}
-	}
-	viper.Set("blacklist", blacklists)
 	h.dmn.index.RemoveBlacklist(pattern)
+	index.SaveProfile(h.dmn.index.Profile())
+
+	// Files which the pattern excluded are added once the lock is released
+	go h.dmn.index.AddMatching(pattern, h.dmn.index.Roots(), h.dmn.lock)
 	return nil
 }
 
 // BlacklistGet returns a list of all blacklisted patterns
 func (h *Handler) BlacklistGet(_ string, res *BlacklistPatterns) error {
+	h.dmn.lock.RLock()
+	defer h.dmn.lock.RUnlock()
+
 	res.Patterns = h.dmn.index.GetBlacklist()
 	return nil
 }
 
 // List returns all directories added to the index
 func (h *Handler) List(_ string, res *DirList) error {
-	res.Dirs = viper.GetStringSlice("dirs")
+	h.dmn.lock.RLock()
+	defer h.dmn.lock.RUnlock()
+
+	res.Dirs = h.dmn.index.Roots()
 	return nil
 }
+
+// CreateIndex creates an empty index with the profile's name and path, and starts
+// serving it. The config is written straight away so that commands run without the
+// daemon see the new index
+func (h *Handler) CreateIndex(p index.Profile, res *bool) error {
+	h.dmn.lock.Lock()
+	defer h.dmn.lock.Unlock()
+
+	p, err := index.CreateProfile(p.Name, p.Path)
+	if err != nil {
+		return err
+	}
+	if _, err := h.dmn.open(p); err != nil {
+		return err
+	}
+	*res = true
+	return viper.WriteConfig()
+}
+
+// UseIndex makes the named index the current one, which requests that manage an
+// index apply to and searches use by default
+func (h *Handler) UseIndex(name string, res *bool) error {
+	h.dmn.lock.Lock()
+	defer h.dmn.lock.Unlock()
+
+	i, ok := h.dmn.indexes[name]
+	if !ok {
+		return errors.New("no index named " + name)
+	}
+	if err := index.UseProfile(name); err != nil {
+		return err
+	}
+	h.dmn.index = i
+	*res = true
+	return viper.WriteConfig()
+}
+
+// ListIndexes returns the configuration of each index, sorted by name
+func (h *Handler) ListIndexes(_ string, res *IndexList) error {
+	h.dmn.lock.RLock()
+	defer h.dmn.lock.RUnlock()
+
+	res.Current = h.dmn.index.Name()
+	for _, i := range h.dmn.indexes {
+		res.Indexes = append(res.Indexes, i.Profile())
+	}
+	sort.Slice(res.Indexes, func(a, b int) bool {
+		return res.Indexes[a].Name < res.Indexes[b].Name
+	})
+	return nil
+}
+
+// Dupes returns groups of documents which are at least as similar as the threshold
+func (h *Handler) Dupes(threshold float64, res *Duplicates) error {
+	h.dmn.lock.RLock()
+	defer h.dmn.lock.RUnlock()
+
+	res.Groups = h.dmn.index.Duplicates(threshold)
+	return nil
+}
+
+// ScanStatus returns the progress of the reconciliation scan run at startup, of the
+// named index or the current one if the name is empty
+func (h *Handler) ScanStatus(name string, res *index.ScanProgress) error {
+	h.dmn.lock.RLock()
+	defer h.dmn.lock.RUnlock()
+
+	i, err := h.dmn.named(name)
+	if err != nil {
+		return err
+	}
+	*res = i.ScanProgress()
+	return nil
+}
+
+// DocInfo returns the metadata of the document with the given path
+func (h *Handler) DocInfo(req DocInfoRequest, res *DocInfo) error {
+	h.dmn.lock.RLock()
+	i, err := h.dmn.named(req.Index)
+	if err != nil {
+		h.dmn.lock.RUnlock()
+		return err
+	}
+	doc, ok := i.GetDocument(req.Path)
+	h.dmn.lock.RUnlock()
+	if !ok {
+		return fmt.Errorf("%v is not in the index", req.Path)
+	}
+
+	meta := doc.Metadata()
+	res.Path = doc.Path()
+	res.Size = doc.Size()
+	res.MIME = meta.MIME
+	res.Title = meta.Title
+	res.Author = meta.Author
+	if doc.ModTime() != 0 {
+		res.Modified = time.Unix(0, doc.ModTime())
+	}
+	if meta.Created != 0 {
+		res.Created = time.Unix(0, meta.Created)
+	}
+
+	res.Owner = fmt.Sprint(meta.Owner)
+	if u, err := user.LookupId(res.Owner); err == nil {
+		res.Owner = u.Username
+	}
+	return nil
+}
+
+// Details summarises the metadata on a single line
+func (d *DocInfo) Details() string {
+	var details []string
+	if d.MIME != "" {
+		details = append(details, d.MIME)
+	}
+	details = append(details, formatSize(d.Size), d.Owner)
+	if !d.Modified.IsZero() {
+		details = append(details, "modified "+d.Modified.Format("2 Jan 2006 15:04"))
+	}
+	return strings.Join(details, ", ")
+}
+
+// Properties summarises the title, author and creation date reported by tika,
+// an empty string is returned if none of them are known
+func (d *DocInfo) Properties() string {
+	var props []string
+	if d.Title != "" {
+		props = append(props, "title: "+d.Title)
+	}
+	if d.Author != "" {
+		props = append(props, "author: "+d.Author)
+	}
+	if !d.Created.IsZero() {
+		props = append(props, "created "+d.Created.Format("2 Jan 2006"))
+	}
+	return strings.Join(props, ", ")
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// Stats returns statistics about the index, including the given number of most frequent terms
+func (h *Handler) Stats(req StatsRequest, res *index.Stats) error {
+	h.dmn.lock.RLock()
+	defer h.dmn.lock.RUnlock()
+
+	i, err := h.dmn.named(req.Index)
+	if err != nil {
+		return err
+	}
+	*res = *i.Stats(req.NumTerms)
+	return nil
+}
+
+// Compact rewrites every partition of the named index, or the current one if the name
+// is empty, which has tombstones, reporting the space reclaimed
+func (h *Handler) Compact(name string, res *index.CompactResult) error {
+	h.dmn.lock.RLock()
+	i, err := h.dmn.named(name)
+	h.dmn.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	*res = i.Compact(h.dmn.lock, 1)
+	return nil
+}
+
+// Export writes a snapshot of the index and the watched directories to the given file
+func (h *Handler) Export(req ExportRequest, res *bool) error {
+	h.dmn.lock.Lock()
+	defer h.dmn.lock.Unlock()
+
+	i, err := h.dmn.named(req.Index)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(req.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	p := i.Profile()
+	config := index.BackupConfig{
+		Dirs:      p.Dirs,
+		Blacklist: p.Blacklist,
+		Roots:     p.Roots,
+	}
+	if err := i.Export(f, config); err != nil {
+		return err
+	}
+	*res = true
+	return f.Sync()
+}
@@ -1,6 +1,7 @@
 package monitordaemon
 
 import (
+	"flash/pkg/index"
 	"log"
 	"os"
 	"path/filepath"
@@ -21,19 +22,19 @@ func newWatcher() *watcher {
 	return &watcher{w}
 }
 
-func (w *watcher) addDir(dir string) error {
+// addDir watches the directory and the directories under it which are allowed by the
+// policy, skipping those excluded by ignore files. Links which the policy follows are
+// watched through their own paths, so that events are reported under them
+func (w *watcher) addDir(dir string, policy index.Policy) error {
 	addDir := func(path string, fi os.FileInfo, err error) error {
-		if fi.Mode().IsDir() {
-			if len(fi.Name()) > 0 && fi.Name()[0:1] == "." {
-				return filepath.SkipDir
-			}
+		if fi != nil && fi.Mode().IsDir() {
 			return w.Add(path)
 		}
 		return nil
 	}
 
 	w.Add(dir)
-	if err := filepath.Walk(dir, addDir); err != nil {
+	if err := policy.Walk(dir, addDir); err != nil {
 		return err
 	}
 
@@ -2,17 +2,117 @@ package blacklist
 
 import (
 	"regexp"
+	"strings"
 )
 
+// GlobPrefix marks a pattern as a glob rather than a regex. A glob without a slash
+// matches any element of a path, otherwise it matches from the start of the path. In
+// either case, a glob which matches a directory matches everything under it
+const GlobPrefix = "glob:"
+
+// Pattern is a compiled blacklist pattern
+type Pattern struct {
+	pattern string
+	reg     *regexp.Regexp
+}
+
+// Compile parses a regex, or a glob if the pattern has the glob prefix
+func Compile(pattern string) (*Pattern, error) {
+	expr := pattern
+	if glob := strings.TrimPrefix(pattern, GlobPrefix); glob != pattern {
+		expr = globToRegex(glob)
+	}
+
+	reg, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &Pattern{pattern, reg}, nil
+}
+
+// Match returns true if the path matches the pattern
+func (p *Pattern) Match(s string) bool {
+	return p.reg.MatchString(s)
+}
+
+// String returns the pattern as it was given
+func (p *Pattern) String() string {
+	return p.pattern
+}
+
+// globToRegex converts a glob to an equivalent regex, where *, ? and character classes
+// don't match slashes and ** matches any number of path elements
+func globToRegex(glob string) string {
+	var b strings.Builder
+	// Slashes inside character classes don't anchor the glob
+	anchored := false
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					i++
+					anchored = true
+					b.WriteString("(.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			if end := strings.IndexByte(glob[i+1:], ']'); end > 0 {
+				class := glob[i+1 : i+1+end]
+				negated := class[0] == '!' || class[0] == '^'
+				if negated {
+					class = class[1:]
+				}
+				class = strings.ReplaceAll(class, "/", "")
+				class = strings.ReplaceAll(class, `\`, `\\`)
+				switch {
+				case negated:
+					b.WriteString("[^/" + class + "]")
+				case class == "":
+					// A class of only slashes can't match anything
+					b.WriteString(`[^\x00-\x{10FFFF}]`)
+				default:
+					b.WriteString("[" + class + "]")
+				}
+				i += end + 1
+			} else {
+				b.WriteString(`\[`)
+			}
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				anchored = anchored || glob[i] == '/'
+				b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			}
+		default:
+			anchored = anchored || c == '/'
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	b.WriteString("(/|$)")
+	if anchored {
+		return "^" + b.String()
+	}
+	return "(^|/)" + b.String()
+}
+
 // Blacklist struct
 type Blacklist struct {
-	patterns []*regexp.Regexp
+	patterns []*Pattern
 }
 
 // Contains returns true if the path is in the blacklist
 func (b *Blacklist) Contains(s string) bool {
-	for _, reg := range b.patterns {
-		if reg.MatchString(s) {
+	for _, p := range b.patterns {
+		if p.Match(s) {
 			return true
 		}
 	}
@@ -21,20 +121,20 @@ func (b *Blacklist) Contains(s string) bool {
 
 // Add adds the given pattern to the blacklist
 func (b *Blacklist) Add(patterns ...string) error {
-	for _, p := range patterns {
-		reg, err := regexp.Compile(p)
+	for _, pattern := range patterns {
+		p, err := Compile(pattern)
 		if err != nil {
 			return err
 		}
-		b.patterns = append(b.patterns, reg)
+		b.patterns = append(b.patterns, p)
 	}
 	return nil
 }
 
 // Remove removes the given pattern from the
 func (b *Blacklist) Remove(pattern string) {
-	for i, reg := range b.patterns {
-		if reg.String() == pattern {
+	for i, p := range b.patterns {
+		if p.String() == pattern {
 			b.patterns[i] = b.patterns[len(b.patterns)-1]
 			b.patterns = b.patterns[:len(b.patterns)-1]
 			return
@@ -53,5 +153,5 @@ func (b *Blacklist) GetPatterns() []string {
 
 // Reset empties the blacklist
 func (b *Blacklist) Reset() {
-	b.patterns = []*regexp.Regexp{}
+	b.patterns = []*Pattern{}
 }
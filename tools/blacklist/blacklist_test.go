@@ -66,3 +66,36 @@ func TestReset(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestGlob(t *testing.T) {
+	b := &Blacklist{}
+	b.Add("glob:*.iso", "glob:node_modules", "glob:/home/*/tmp/**/*.log", "glob:file[!0-9].txt")
+	for _, path := range []string{"/data/disk.iso", "/src/node_modules/a/b.js", "/home/x/tmp/a.log", "/home/x/tmp/a/b/c.log", "/a/filex.txt"} {
+		if !b.Contains(path) {
+			t.Errorf("%v isn't blacklisted", path)
+		}
+	}
+	for _, path := range []string{"/data/disk.iso.txt", "/src/node_modules2/a.js", "/home/x/y/tmp/a.log", "/a/file1.txt"} {
+		if b.Contains(path) {
+			t.Errorf("%v is blacklisted", path)
+		}
+	}
+	if patterns := b.GetPatterns(); patterns[0] != "glob:*.iso" {
+		t.Fail()
+	}
+}
+
+func TestGlobClassSlash(t *testing.T) {
+	b := &Blacklist{}
+	b.Add("glob:*.[!c]", "glob:a[!x]b", "glob:c[d/]e", "glob:f[/]g")
+	for _, path := range []string{"/src/a.o", "/aib", "/cde"} {
+		if !b.Contains(path) {
+			t.Errorf("%v isn't blacklisted", path)
+		}
+	}
+	for _, path := range []string{"a/b", "/src/a.c", "/a/b", "/c/e", "/f/g"} {
+		if b.Contains(path) {
+			t.Errorf("%v is blacklisted, a class crossed a directory", path)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package ignore
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Files are the names of the ignore files read from each directory. Patterns in later
+// files take precedence over those in earlier ones
+var Files = []string{".gitignore", ".flashignore"}
+
+// pattern is a single line of an ignore file, which applies to the paths under base
+type pattern struct {
+	base     string
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+// Rules are the patterns of the ignore files in a directory and those above it. Like
+// git, the last pattern which matches a path decides whether it's ignored, so the
+// patterns of a directory take precedence over those of its parents
+type Rules struct {
+	patterns []pattern
+}
+
+// Add returns the rules extended with the ignore files in the directory. The rules
+// are not changed, and may be nil
+func (r *Rules) Add(dir string) *Rules {
+	var patterns []pattern
+	for _, name := range Files {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		patterns = append(patterns, parse(dir, f)...)
+		f.Close()
+	}
+
+	if len(patterns) == 0 {
+		return r
+	}
+	extended := &Rules{}
+	if r != nil {
+		extended.patterns = append(extended.patterns, r.patterns...)
+	}
+	extended.patterns = append(extended.patterns, patterns...)
+	return extended
+}
+
+// Match returns true if the path is ignored by the rules. Only the path itself is
+// checked, not the directories above it
+func (r *Rules) Match(name string, dir bool) bool {
+	if r == nil {
+		return false
+	}
+	for i := len(r.patterns) - 1; i >= 0; i-- {
+		if p := r.patterns[i]; p.match(name, dir) {
+			return !p.negate
+		}
+	}
+	return false
+}
+
+// parse reads the patterns of an ignore file in the base directory
+func parse(base string, r io.Reader) []pattern {
+	var patterns []pattern
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if p, ok := parseLine(base, scanner.Text()); ok {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+func parseLine(base, line string) (pattern, bool) {
+	p := pattern{base: base}
+
+	// Trailing spaces are ignored unless they're escaped
+	line = strings.TrimRight(line, " \t\r")
+	if strings.HasSuffix(line, "\\") {
+		line += " "
+	}
+	if line == "" || line[0] == '#' {
+		return p, false
+	}
+
+	if line[0] == '!' {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return p, false
+	}
+
+	// A pattern with a slash before its end is relative to the ignore file's directory,
+	// otherwise it matches a name at any depth below it
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	p.segments = strings.Split(line, "/")
+	for i, segment := range p.segments {
+		p.segments[i] = negateClasses(segment)
+	}
+	if !anchored {
+		p.segments = append([]string{"**"}, p.segments...)
+	}
+	return p, true
+}
+
+// negateClasses rewrites the character classes negated with [!...], as in gitignore,
+// to the [^...] understood by path.Match
+func negateClasses(segment string) string {
+	b := []byte(segment)
+	for i := 0; i < len(b); i++ {
+		switch {
+		case b[i] == '\\':
+			i++
+		case b[i] == '[' && i+1 < len(b) && b[i+1] == '!':
+			b[i+1] = '^'
+		}
+	}
+	return string(b)
+}
+
+func (p pattern) match(name string, dir bool) bool {
+	if p.dirOnly && !dir {
+		return false
+	}
+	rel, err := filepath.Rel(p.base, name)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	return matchSegments(p.segments, strings.Split(filepath.ToSlash(rel), "/"))
+}
+
+// matchSegments matches the elements of a path against those of a pattern, where
+// ** matches any number of elements. A trailing ** only matches paths inside a directory
+func matchSegments(segments, elements []string) bool {
+	for len(segments) > 0 {
+		if segments[0] == "**" {
+			segments = segments[1:]
+			if len(segments) == 0 {
+				return len(elements) > 0
+			}
+			for i := range elements {
+				if matchSegments(segments, elements[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(elements) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(segments[0], elements[0]); !ok {
+			return false
+		}
+		segments, elements = segments[1:], elements[1:]
+	}
+	return len(elements) == 0
+}
+
+// Tree finds the ignored paths under a root directory, reading the ignore files of
+// each directory once. Ignore files above the root aren't read
+type Tree struct {
+	root    string
+	rules   map[string]*Rules
+	ignored map[string]bool
+}
+
+// NewTree creates a tree for the paths under the root
+func NewTree(root string) *Tree {
+	return &Tree{
+		root:    filepath.Clean(root),
+		rules:   make(map[string]*Rules),
+		ignored: make(map[string]bool),
+	}
+}
+
+// Ignored returns true if the path, or any directory between it and the root, is ignored
+func (t *Tree) Ignored(name string, dir bool) bool {
+	name = filepath.Clean(name)
+	if name == t.root || !t.contains(name) {
+		return false
+	}
+
+	parent := filepath.Dir(name)
+	if parent != t.root && t.dirIgnored(parent) {
+		return true
+	}
+	return t.rulesOf(parent).Match(name, dir)
+}
+
+func (t *Tree) dirIgnored(dir string) bool {
+	ignored, ok := t.ignored[dir]
+	if !ok {
+		ignored = t.Ignored(dir, true)
+		t.ignored[dir] = ignored
+	}
+	return ignored
+}
+
+// rulesOf returns the rules which apply to the entries of the directory
+func (t *Tree) rulesOf(dir string) *Rules {
+	if r, ok := t.rules[dir]; ok {
+		return r
+	}
+
+	var parent *Rules
+	if dir != t.root {
+		parent = t.rulesOf(filepath.Dir(dir))
+	}
+	r := parent.Add(dir)
+	t.rules[dir] = r
+	return r
+}
+
+func (t *Tree) contains(name string) bool {
+	return strings.HasPrefix(name, strings.TrimSuffix(t.root, string(filepath.Separator))+string(filepath.Separator))
+}
@@ -0,0 +1,127 @@
+package ignore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func rules(lines ...string) *Rules {
+	return &Rules{patterns: parse("/r", strings.NewReader(strings.Join(lines, "\n")))}
+}
+
+func TestMatchName(t *testing.T) {
+	r := rules("# comment", "", "*.o", "node_modules")
+	if !r.Match("/r/a.o", false) || !r.Match("/r/src/b.o", false) || !r.Match("/r/x/node_modules", true) {
+		t.Fail()
+	}
+	if r.Match("/r/a.c", false) || r.Match("/other/a.o", false) || r.Match("/r/# comment", false) {
+		t.Fail()
+	}
+}
+
+func TestMatchAnchored(t *testing.T) {
+	r := rules("/build", "docs/*.tmp")
+	if !r.Match("/r/build", true) || r.Match("/r/src/build", true) {
+		t.Fail()
+	}
+	if !r.Match("/r/docs/a.tmp", false) || r.Match("/r/docs/sub/a.tmp", false) || r.Match("/r/x/docs/a.tmp", false) {
+		t.Fail()
+	}
+}
+
+func TestMatchDirOnly(t *testing.T) {
+	r := rules("target/")
+	if !r.Match("/r/target", true) || r.Match("/r/target", false) {
+		t.Fail()
+	}
+}
+
+func TestMatchDoubleStar(t *testing.T) {
+	r := rules("**/logs", "a/**/b", "out/**")
+	if !r.Match("/r/logs", true) || !r.Match("/r/x/y/logs", true) {
+		t.Fail()
+	}
+	if !r.Match("/r/a/b", false) || !r.Match("/r/a/x/y/b", false) || r.Match("/r/x/a/b", false) {
+		t.Fail()
+	}
+	if !r.Match("/r/out/x", false) || r.Match("/r/out", true) {
+		t.Fail()
+	}
+}
+
+func TestMatchNegate(t *testing.T) {
+	r := rules("*.log", "!keep.log", "\\!bang")
+	if !r.Match("/r/a.log", false) || r.Match("/r/keep.log", false) || !r.Match("/r/!bang", false) {
+		t.Fail()
+	}
+
+	// The last matching pattern wins
+	r = rules("!keep.log", "*.log")
+	if !r.Match("/r/keep.log", false) {
+		t.Fail()
+	}
+}
+
+func TestMatchNegatedClass(t *testing.T) {
+	r := rules("*.[!ch]", "\\[!x]")
+	if !r.Match("/r/a.o", false) || r.Match("/r/a.c", false) || r.Match("/r/a.h", false) {
+		t.Fail()
+	}
+	if !r.Match("/r/[!x]", false) || r.Match("/r/a", false) {
+		t.Fail()
+	}
+}
+
+func TestTree(t *testing.T) {
+	root, _ := ioutil.TempDir("", "ignore")
+	defer os.RemoveAll(root)
+
+	files := map[string]string{
+		".gitignore":              "*.tmp\nbuild/\n",
+		"a.txt":                   "",
+		"a.tmp":                   "",
+		"build/out.txt":           "",
+		"src/.flashignore":        "!keep.tmp\n/gen\n",
+		"src/keep.tmp":            "",
+		"src/gen/x.txt":           "",
+		"src/lib/gen/y.txt":       "",
+		"node_modules/.gitignore": "*\n",
+		"node_modules/z.txt":      "",
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755)
+		ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0644)
+	}
+
+	var found []string
+	tree := NewTree(root)
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if tree.Ignored(path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && info.Name()[0] != '.' {
+			rel, _ := filepath.Rel(root, path)
+			found = append(found, rel)
+		}
+		return nil
+	})
+	sort.Strings(found)
+
+	expected := []string{"a.txt", "src/keep.tmp", "src/lib/gen/y.txt"}
+	if strings.Join(found, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v, found %v", expected, found)
+	}
+
+	// Paths are checked along with the directories above them
+	tree = NewTree(root)
+	if !tree.Ignored(filepath.Join(root, "build/out.txt"), false) || tree.Ignored(filepath.Join(root, "src/keep.tmp"), false) {
+		t.Fail()
+	}
+}
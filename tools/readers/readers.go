@@ -25,3 +25,9 @@ func ReadFloat64(reader io.Reader) float64 {
 	binary.Read(reader, binary.LittleEndian, &val)
 	return val
 }
+
+// ReadUvarint reads a variable length encoded uint64 from the reader
+func ReadUvarint(reader io.ByteReader) uint64 {
+	val, _ := binary.ReadUvarint(reader)
+	return val
+}
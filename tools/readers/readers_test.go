@@ -32,3 +32,12 @@ func TestFloat64(t *testing.T) {
 		t.Error(res)
 	}
 }
+
+func TestUvarint(t *testing.T) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, 300)
+	res := ReadUvarint(bytes.NewBuffer(buf[:n]))
+	if n != 2 || res != 300 {
+		t.Error(n, res)
+	}
+}
@@ -0,0 +1,45 @@
+package simhash
+
+import (
+	"hash/fnv"
+	"math/bits"
+)
+
+// Size is the number of bits in a fingerprint
+const Size = 64
+
+// Hash calculates the simhash fingerprint of a document, given the frequency of each of its terms
+func Hash(terms map[string]uint32) uint64 {
+	var weights [Size]int64
+	for term, freq := range terms {
+		h := fnv.New64a()
+		h.Write([]byte(term))
+		sum := h.Sum64()
+
+		for i := 0; i < Size; i++ {
+			if sum&(1<<uint(i)) != 0 {
+				weights[i] += int64(freq)
+			} else {
+				weights[i] -= int64(freq)
+			}
+		}
+	}
+
+	var fingerprint uint64
+	for i := 0; i < Size; i++ {
+		if weights[i] > 0 {
+			fingerprint |= 1 << uint(i)
+		}
+	}
+	return fingerprint
+}
+
+// Distance returns the number of bits which differ between two fingerprints
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Similarity returns a value between 0 and 1 indicating how similar two fingerprints are
+func Similarity(a, b uint64) float64 {
+	return 1 - float64(Distance(a, b))/Size
+}
@@ -0,0 +1,51 @@
+package simhash
+
+import (
+	"testing"
+)
+
+func frequencies(words ...string) map[string]uint32 {
+	terms := make(map[string]uint32)
+	for _, w := range words {
+		terms[w]++
+	}
+	return terms
+}
+
+func TestIdentical(t *testing.T) {
+	a := Hash(frequencies("the", "quick", "brown", "fox"))
+	b := Hash(frequencies("fox", "brown", "quick", "the"))
+	if a != b {
+		t.Fail()
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	if Hash(frequencies()) != 0 {
+		t.Fail()
+	}
+}
+
+func TestSimilar(t *testing.T) {
+	words := []string{"lorem", "ipsum", "dolor", "sit", "amet", "consectetur", "adipiscing", "elit", "sed",
+		"do", "eiusmod", "tempor", "incididunt", "ut", "labore", "et", "dolore", "magna", "aliqua"}
+	a := Hash(frequencies(words...))
+	b := Hash(frequencies(append(words, "final")...))
+	c := Hash(frequencies("something", "completely", "different"))
+
+	if Similarity(a, b) <= Similarity(a, c) {
+		t.Error(Similarity(a, b), Similarity(a, c))
+	}
+}
+
+func TestDistance(t *testing.T) {
+	if Distance(0, 0) != 0 || Distance(0, 7) != 3 || Distance(0, ^uint64(0)) != Size {
+		t.Fail()
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	if Similarity(5, 5) != 1 || Similarity(0, ^uint64(0)) != 0 {
+		t.Fail()
+	}
+}